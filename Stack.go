@@ -1,39 +1,120 @@
 package stream
 
-import "container/list"
+import (
+	"errors"
+	"sync"
+)
 
-type Stack struct {
-	stack *list.List
+var StackOverflowError = errors.New("stack is full")
+
+// Stack is a last in, first out stack safe for concurrent use. A Stack made
+// with NewBoundedStack refuses to grow beyond its maximum depth.
+type Stack[T any] struct {
+	items    []T
+	maxDepth int
+	mutex    sync.RWMutex
 }
 
-func NewStack() *Stack {
-	var stack = &Stack{
-		stack: list.New(),
-	}
-	return stack
+func NewStack[T any]() *Stack[T] {
+	return NewBoundedStack[T](0)
 }
 
-func (s *Stack) Push(v interface{}) {
-	s.stack.PushFront(v)
+// NewBoundedStack returns a Stack holding at most maxDepth values, or any
+// number of them when maxDepth is not positive.
+func NewBoundedStack[T any](maxDepth int) *Stack[T] {
+	return &Stack[T]{
+		items:    make([]T, 0),
+		maxDepth: maxDepth,
+	}
 }
 
-func (s *Stack) Pop(v interface{}) interface{} {
-	front := s.stack.Front()
-	if front != nil {
-		s.stack.Remove(front)
-		return front.Value
+// Push puts v on top of the stack, or returns StackOverflowError when the
+// stack is full.
+func (s *Stack[T]) Push(v T) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.maxDepth > 0 && len(s.items) >= s.maxDepth {
+		return StackOverflowError
 	}
+	s.items = append(s.items, v)
 	return nil
 }
 
-func (s *Stack) Len() int {
-	return s.stack.Len()
+// Pop removes and returns the value on top of the stack, and reports
+// whether there was one.
+func (s *Stack[T]) Pop() (T, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var zero T
+	n := len(s.items)
+	if n == 0 {
+		return zero, false
+	}
+	v := s.items[n-1]
+	s.items[n-1] = zero
+	s.items = s.items[:n-1]
+	return v, true
+}
+
+// Peek returns the value on top of the stack without removing it, and
+// reports whether there was one.
+func (s *Stack[T]) Peek() (T, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if len(s.items) == 0 {
+		var zero T
+		return zero, false
+	}
+	return s.items[len(s.items)-1], true
+}
+
+func (s *Stack[T]) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.items)
 }
 
-func (s *Stack) CheckCirculation(n int, compareFunc CompareFunc) bool {
-	if s.Len()/n < 2 {
+// Clear removes every value.
+func (s *Stack[T]) Clear() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.items = make([]T, 0)
+}
+
+// Each calls fn with the values from the top of the stack down, until fn
+// returns false. It sees the stack as it was when called.
+func (s *Stack[T]) Each(fn func(T) bool) {
+	for _, v := range s.Values() {
+		if !fn(v) {
+			return
+		}
+	}
+}
+
+// Values returns the values from the top of the stack down.
+func (s *Stack[T]) Values() []T {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	values := make([]T, len(s.items))
+	for i, v := range s.items {
+		values[len(s.items)-1-i] = v
+	}
+	return values
+}
+
+// CheckCirculation reports whether the top n values repeat the n values
+// below them, as happens when a traversal keeps going round the same loop.
+func (s *Stack[T]) CheckCirculation(n int, compareFunc func(a, b T) int) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	size := len(s.items)
+	if n <= 0 || size/n < 2 {
 		return false
 	}
-	//todo
+	for i := 0; i < n; i++ {
+		if compareFunc(s.items[size-1-i], s.items[size-1-n-i]) != 0 {
+			return false
+		}
+	}
 	return true
 }
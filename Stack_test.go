@@ -0,0 +1,45 @@
+package stream
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStack_CheckCirculation(t *testing.T) {
+	s := NewStack[string]()
+	for _, v := range []string{"x", "a", "b", "a", "b"} {
+		s.Push(v)
+	}
+	if !s.CheckCirculation(2, strings.Compare) {
+		t.Fatal("expected a, b to repeat")
+	}
+	if s.CheckCirculation(1, strings.Compare) {
+		t.Fatal("expected no repetition of period 1")
+	}
+}
+
+func TestStack(t *testing.T) {
+	s := NewBoundedStack[int](3)
+	for i := 1; i <= 3; i++ {
+		if err := s.Push(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Push(4); err != StackOverflowError {
+		t.Fatalf("expected StackOverflowError, got %v", err)
+	}
+	if v, ok := s.Peek(); !ok || v != 3 || s.Len() != 3 {
+		t.Fatalf("expected to peek 3, got %v, %v", v, ok)
+	}
+	if values := s.Values(); !reflect.DeepEqual(values, []int{3, 2, 1}) {
+		t.Fatalf("expected [3 2 1], got %v", values)
+	}
+	if v, ok := s.Pop(); !ok || v != 3 {
+		t.Fatalf("expected to pop 3, got %v, %v", v, ok)
+	}
+	s.Clear()
+	if v, ok := s.Pop(); ok || v != 0 || s.Len() != 0 {
+		t.Fatalf("expected an empty stack, got %v, %v", v, ok)
+	}
+}
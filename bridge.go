@@ -0,0 +1,65 @@
+package stream
+
+import "errors"
+
+var EmptyStreamError = errors.New("stream closed without items")
+
+// Collect returns a promise resolved with every item of s, in order, once
+// s is closed. Barriers are left out.
+func (s Stream) Collect() *Promise {
+	return NewPromise(func(resolve func(interface{}), reject func(error)) {
+		items := make([]interface{}, 0)
+		for v := range s {
+			if IsBarrier(v) {
+				continue
+			}
+			items = append(items, v)
+		}
+		resolve(items)
+	})
+}
+
+// FirstPromise returns a promise resolved with the first item of s, or
+// rejected with EmptyStreamError if s is closed first. The rest of s is
+// left to the caller. Barriers are skipped. An error item rejects it.
+func (s Stream) FirstPromise() *Promise {
+	return NewPromise(func(resolve func(interface{}), reject func(error)) {
+		for v := range s {
+			if IsBarrier(v) {
+				continue
+			}
+			resolve(v)
+			return
+		}
+		reject(EmptyStreamError)
+	})
+}
+
+// FromPromise returns a Stream emitting the result of p, or its error when
+// it is rejected, then closed.
+func FromPromise(p *Promise) Stream {
+	return FromPromises(p)
+}
+
+// FromPromises returns a Stream emitting the results of ps, or their errors
+// when they are rejected, in the order they settle, then closed.
+func FromPromises(ps ...*Promise) Stream {
+	out := make(chan interface{})
+	settled := make(chan interface{}, len(ps))
+	for _, p := range ps {
+		p.subscribe(func(result interface{}, err error) {
+			if err != nil {
+				settled <- err
+			} else {
+				settled <- result
+			}
+		})
+	}
+	go func() {
+		for range ps {
+			out <- <-settled
+		}
+		close(out)
+	}()
+	return Stream(out)
+}
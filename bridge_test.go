@@ -0,0 +1,49 @@
+package stream
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStream_Collect(t *testing.T) {
+	s := New(0)
+	go func() {
+		s <- 1
+		s <- Barrier{ID: 1}
+		s <- 2
+		close(s)
+	}()
+	if v, err := s.Collect().Await(); err != nil || !reflect.DeepEqual(v, []interface{}{1, 2}) {
+		t.Fatalf("expected [1 2], got %v, %v", v, err)
+	}
+	if v, err := Just("a", "b").FirstPromise().Await(); err != nil || v != "a" {
+		t.Fatalf("expected a, got %v, %v", v, err)
+	}
+	if _, err := Empty().FirstPromise().Await(); err != EmptyStreamError {
+		t.Fatalf("expected EmptyStreamError, got %v", err)
+	}
+}
+
+func TestFromPromises(t *testing.T) {
+	failed := errors.New("failed")
+	slow := NewPromise(func(resolve func(interface{}), reject func(error)) {
+		time.Sleep(20 * time.Millisecond)
+		resolve("slow")
+	})
+	fast := NewPromise(func(resolve func(interface{}), reject func(error)) {
+		time.Sleep(5 * time.Millisecond)
+		reject(failed)
+	})
+	got := make([]interface{}, 0)
+	for v := range FromPromises(slow, fast, Resolve("now")) {
+		got = append(got, v)
+	}
+	if !reflect.DeepEqual(got, []interface{}{"now", failed, "slow"}) {
+		t.Fatalf("expected completion order, got %v", got)
+	}
+	if v, err := FromPromise(Resolve(1)).Collect().Await(); err != nil || !reflect.DeepEqual(v, []interface{}{1}) {
+		t.Fatalf("expected [1], got %v, %v", v, err)
+	}
+}
@@ -0,0 +1,311 @@
+package stream
+
+import (
+	"sync"
+)
+
+// SlowPolicy decides what happens to an item when a subscriber's buffer is full.
+type SlowPolicy int
+
+const (
+	// Block waits for the subscriber, holding back every other subscriber.
+	Block SlowPolicy = iota
+	// Drop skips the item for that subscriber only.
+	Drop
+	// Disconnect skips the item and closes the subscriber's Stream.
+	Disconnect
+)
+
+type subscriber struct {
+	out     Stream
+	policy  SlowPolicy
+	done    chan struct{}
+	stop    sync.Once
+	closed  bool
+	dropped uint64
+	mutex   sync.Mutex
+}
+
+func newSubscriber(buffer int, policy SlowPolicy) *subscriber {
+	return &subscriber{
+		out:    New(buffer),
+		policy: policy,
+		done:   make(chan struct{}),
+	}
+}
+
+type sendResult int
+
+const (
+	sent sendResult = iota
+	dropped
+	disconnected
+	interrupted
+)
+
+// send delivers v according to the subscriber's policy.
+func (sub *subscriber) send(v interface{}) sendResult {
+	return sub.sendUntil(v, nil)
+}
+
+// sendUntil is send giving up on a blocked subscriber once stop is closed.
+func (sub *subscriber) sendUntil(v interface{}, stop <-chan struct{}) sendResult {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+	if sub.closed {
+		return disconnected
+	}
+	switch sub.policy {
+	case Drop:
+		select {
+		case sub.out <- v:
+		default:
+			sub.dropped++
+			return dropped
+		}
+	case Disconnect:
+		select {
+		case sub.out <- v:
+		default:
+			sub.dropped++
+			sub.closeLocked()
+			return disconnected
+		}
+	default:
+		select {
+		case sub.out <- v:
+		case <-sub.done:
+			return disconnected
+		case <-stop:
+			return interrupted
+		}
+	}
+	return sent
+}
+
+func (sub *subscriber) close() {
+	sub.stop.Do(func() {
+		close(sub.done)
+	})
+	sub.mutex.Lock()
+	sub.closeLocked()
+	sub.mutex.Unlock()
+}
+
+func (sub *subscriber) closeLocked() {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.out)
+}
+
+// Broadcast multicasts one source Stream to any number of subscribers, each
+// with its own Stream, buffer and SlowPolicy. Items are only read from the
+// source while the Broadcast is connected; subscribers that join later miss
+// the items sent before they joined.
+type Broadcast struct {
+	source      Stream
+	subscribers map[Stream]*subscriber
+	held        *heldItem
+	refCount    bool
+	connected   bool
+	completed   bool
+	stop        chan struct{}
+	stopped     chan struct{}
+	mutex       sync.Mutex
+}
+
+// heldItem is an item a Disconnect interrupted, with the subscribers it
+// has not been sent to yet.
+type heldItem struct {
+	v    interface{}
+	subs []*subscriber
+}
+
+// Publish returns a Broadcast of source. It has to be connected with
+// Connect, or with RefCount to connect on the first subscriber.
+func Publish(source Stream) *Broadcast {
+	return &Broadcast{
+		source:      source,
+		subscribers: make(map[Stream]*subscriber),
+	}
+}
+
+func (s Stream) Publish() *Broadcast {
+	return Publish(s)
+}
+
+// RefCount makes the Broadcast connect when the first subscriber joins and
+// disconnect when the last one leaves.
+func (b *Broadcast) RefCount() *Broadcast {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refCount = true
+	if len(b.subscribers) > 0 {
+		b.connectLocked()
+	}
+	return b
+}
+
+// Subscribe returns a new Stream receiving every item the source emits from
+// now on. It is closed when the source completes, on Unsubscribe, or by the
+// Disconnect policy.
+func (b *Broadcast) Subscribe(buffer int, policy SlowPolicy) Stream {
+	sub := newSubscriber(buffer, policy)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.completed {
+		sub.close()
+		return sub.out
+	}
+	b.subscribers[sub.out] = sub
+	if b.refCount {
+		b.connectLocked()
+	}
+	return sub.out
+}
+
+// Unsubscribe closes a Stream returned by Subscribe.
+func (b *Broadcast) Unsubscribe(s Stream) {
+	b.mutex.Lock()
+	sub, ok := b.subscribers[s]
+	delete(b.subscribers, s)
+	disconnect := b.refCount && len(b.subscribers) == 0
+	b.mutex.Unlock()
+	if ok {
+		sub.close()
+	}
+	if disconnect {
+		b.disconnect(true)
+	}
+}
+
+// Subscribers returns the number of connected subscribers.
+func (b *Broadcast) Subscribers() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.subscribers)
+}
+
+// Dropped returns how many items were skipped for s because it was too slow.
+func (b *Broadcast) Dropped(s Stream) uint64 {
+	b.mutex.Lock()
+	sub, ok := b.subscribers[s]
+	b.mutex.Unlock()
+	if !ok {
+		return 0
+	}
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+	return sub.dropped
+}
+
+// Connect starts reading the source.
+func (b *Broadcast) Connect() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.connectLocked()
+}
+
+func (b *Broadcast) connectLocked() {
+	if b.connected || b.completed {
+		return
+	}
+	b.connected = true
+	previous := b.stopped
+	b.stop = make(chan struct{})
+	b.stopped = make(chan struct{})
+	go b.pump(previous, b.stop, b.stopped)
+}
+
+// Disconnect stops reading the source, leaving unread items in it for a
+// later Connect. Subscribers stay subscribed. An item that a Block
+// subscriber was holding back is sent to the remaining subscribers on the
+// next Connect.
+func (b *Broadcast) Disconnect() {
+	b.disconnect(false)
+}
+
+// disconnect stops the pump. With unused, it only does so while RefCount
+// is set and nobody is subscribed, which a Subscribe may have changed since
+// the last Unsubscribe decided to disconnect.
+func (b *Broadcast) disconnect(unused bool) {
+	b.mutex.Lock()
+	if !b.connected || unused && (!b.refCount || len(b.subscribers) > 0) {
+		b.mutex.Unlock()
+		return
+	}
+	b.connected = false
+	stop, stopped := b.stop, b.stopped
+	b.mutex.Unlock()
+	close(stop)
+	<-stopped
+}
+
+// pump reads the source until stop is closed. It starts once the pump of
+// the previous connection, if any, has fully stopped, so that it sees the
+// item that one held.
+func (b *Broadcast) pump(previous, stop, stopped chan struct{}) {
+	defer close(stopped)
+	if previous != nil {
+		<-previous
+	}
+	b.mutex.Lock()
+	held := b.held
+	b.held = nil
+	b.mutex.Unlock()
+	if held != nil && !b.deliver(held.v, held.subs, stop) {
+		return
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case v, ok := <-b.source:
+			if !ok {
+				b.complete()
+				return
+			}
+			b.mutex.Lock()
+			subs := make([]*subscriber, 0, len(b.subscribers))
+			for _, sub := range b.subscribers {
+				subs = append(subs, sub)
+			}
+			b.mutex.Unlock()
+			if !b.deliver(v, subs, stop) {
+				return
+			}
+		}
+	}
+}
+
+// deliver sends v to subs, and reports false when stop interrupted it.
+func (b *Broadcast) deliver(v interface{}, subs []*subscriber, stop chan struct{}) bool {
+	for i, sub := range subs {
+		switch sub.sendUntil(v, stop) {
+		case disconnected:
+			b.mutex.Lock()
+			delete(b.subscribers, sub.out)
+			b.mutex.Unlock()
+		case interrupted:
+			b.mutex.Lock()
+			b.held = &heldItem{v: v, subs: subs[i:]}
+			b.mutex.Unlock()
+			return false
+		}
+	}
+	return true
+}
+
+func (b *Broadcast) complete() {
+	b.mutex.Lock()
+	b.completed = true
+	b.connected = false
+	subs := b.subscribers
+	b.subscribers = make(map[Stream]*subscriber)
+	b.mutex.Unlock()
+	for _, sub := range subs {
+		sub.close()
+	}
+}
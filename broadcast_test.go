@@ -0,0 +1,163 @@
+package stream
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBroadcast_Subscribe(t *testing.T) {
+	b := Range(0, 100).Publish()
+	a := b.Subscribe(0, Block)
+	c := b.Subscribe(0, Block)
+	b.Connect()
+
+	var wg sync.WaitGroup
+	counts := make([]int, 2)
+	for i, s := range []Stream{a, c} {
+		wg.Add(1)
+		go func(i int, s Stream) {
+			defer wg.Done()
+			for range s {
+				counts[i]++
+			}
+		}(i, s)
+	}
+	wg.Wait()
+	if counts[0] != 100 || counts[1] != 100 {
+		t.Fatalf("expected every subscriber to see 100 items, got %v", counts)
+	}
+}
+
+func TestBroadcast_SlowPolicy(t *testing.T) {
+	source := New(0)
+	b := Publish(source)
+	fast := b.Subscribe(0, Block)
+	dropping := b.Subscribe(1, Drop)
+	disconnecting := b.Subscribe(1, Disconnect)
+	b.Connect()
+
+	for i := 0; i < 3; i++ {
+		source <- i
+		<-fast
+	}
+	if v := <-dropping; v != 0 {
+		t.Fatalf("expected the dropping subscriber to keep the first item, got %v", v)
+	}
+	if b.Subscribers() != 2 {
+		t.Fatalf("expected the slow subscriber to be disconnected, got %d subscribers", b.Subscribers())
+	}
+	if v, ok := <-disconnecting; !ok || v != 0 {
+		t.Fatalf("expected the buffered item before the disconnect, got %v %v", v, ok)
+	}
+	if _, ok := <-disconnecting; ok {
+		t.Fatal("expected the disconnected subscriber to be closed")
+	}
+	close(source)
+}
+
+func TestBroadcast_RefCount(t *testing.T) {
+	source := New(0)
+	b := Publish(source).RefCount()
+	s := b.Subscribe(0, Block)
+	source <- 1
+	if v := <-s; v != 1 {
+		t.Fatalf("expected 1, got %v", v)
+	}
+	b.Unsubscribe(s)
+	select {
+	case source <- 2:
+		t.Fatal("expected the broadcast to stop reading without subscribers")
+	default:
+	}
+	s = b.Subscribe(0, Block)
+	source <- 3
+	if v := <-s; v != 3 {
+		t.Fatalf("expected 3, got %v", v)
+	}
+}
+
+func TestBroadcast_DisconnectBlocked(t *testing.T) {
+	source := New(1)
+	b := Publish(source)
+	s := b.Subscribe(0, Block)
+	b.Connect()
+	source <- 1
+	for len(source) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		b.Disconnect()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Disconnect hung on a blocked subscriber")
+	}
+
+	b.Connect()
+	select {
+	case v := <-s:
+		if v != 1 {
+			t.Fatalf("expected the held item 1, got %v", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the held item was not sent after Connect")
+	}
+	close(source)
+	for range s {
+	}
+}
+
+func TestBroadcast_RefCountResubscribe(t *testing.T) {
+	source := New(0)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case source <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+	b := Publish(source).RefCount()
+
+	// a Subscribe between the last Unsubscribe and its disconnect keeps
+	// the broadcast connected
+	old := b.Subscribe(1, Drop)
+	b.mutex.Lock()
+	sub := b.subscribers[old]
+	delete(b.subscribers, old)
+	b.mutex.Unlock()
+	sub.close()
+	s := b.Subscribe(1, Drop)
+	b.disconnect(true)
+	select {
+	case <-s:
+	case <-time.After(time.Second):
+		t.Fatal("the new subscriber was left on a disconnected broadcast")
+	}
+	b.Unsubscribe(s)
+
+	for i := 0; i < 200; i++ {
+		old := b.Subscribe(1, Drop)
+		unsubscribed := make(chan struct{})
+		go func() {
+			b.Unsubscribe(old)
+			close(unsubscribed)
+		}()
+		s := b.Subscribe(1, Drop)
+		<-unsubscribed
+		select {
+		case <-s:
+		case <-time.After(time.Second):
+			t.Fatalf("round %d: the new subscriber was left on a disconnected broadcast", i)
+		}
+		b.Unsubscribe(s)
+	}
+}
@@ -0,0 +1,219 @@
+package stream
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	InvalidTopicError   = errors.New("invalid topic")
+	InvalidPatternError = errors.New("invalid subscription pattern")
+)
+
+const (
+	topicSeparator = "."
+	matchOne       = "*"
+	matchRest      = ">"
+)
+
+// Message is what a Bus subscriber receives: the item and the topic it was
+// published on.
+type Message struct {
+	Topic string
+	Item  interface{}
+}
+
+// TopicStats counts the traffic of one topic. Subscribers is the number of
+// subscriptions currently matching the topic.
+type TopicStats struct {
+	Published   uint64
+	Delivered   uint64
+	Dropped     uint64
+	Subscribers int
+}
+
+type busSubscription struct {
+	*subscriber
+	pattern []string
+}
+
+// Bus is an in-process publish/subscribe hub. Topics are dot separated
+// names such as "orders.eu.created". Subscription patterns may use "*" to
+// match exactly one segment and a trailing ">" to match one or more.
+type Bus struct {
+	subscriptions map[Stream]*busSubscription
+	stats         map[string]*TopicStats
+	closed        bool
+	mutex         sync.RWMutex
+	statsMutex    sync.Mutex
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subscriptions: make(map[Stream]*busSubscription),
+		stats:         make(map[string]*TopicStats),
+	}
+}
+
+func splitTopic(topic string) ([]string, bool) {
+	if topic == "" {
+		return nil, false
+	}
+	segments := strings.Split(topic, topicSeparator)
+	for _, seg := range segments {
+		if seg == "" {
+			return nil, false
+		}
+	}
+	return segments, true
+}
+
+func validPattern(pattern []string) bool {
+	for i, seg := range pattern {
+		if seg == matchRest && i != len(pattern)-1 {
+			return false
+		}
+	}
+	return true
+}
+
+func matchTopic(pattern []string, topic []string) bool {
+	for i, seg := range pattern {
+		if seg == matchRest {
+			return len(topic) > i
+		}
+		if i >= len(topic) {
+			return false
+		}
+		if seg != matchOne && seg != topic[i] {
+			return false
+		}
+	}
+	return len(pattern) == len(topic)
+}
+
+// Publish sends item to every subscription matching topic and returns how
+// many received it.
+func (b *Bus) Publish(topic string, item interface{}) (int, error) {
+	segments, ok := splitTopic(topic)
+	if !ok {
+		return 0, InvalidTopicError
+	}
+	for _, seg := range segments {
+		if seg == matchOne || seg == matchRest {
+			return 0, InvalidTopicError
+		}
+	}
+	b.mutex.RLock()
+	matched := make([]*busSubscription, 0)
+	for _, sub := range b.subscriptions {
+		if matchTopic(sub.pattern, segments) {
+			matched = append(matched, sub)
+		}
+	}
+	b.mutex.RUnlock()
+
+	msg := Message{Topic: topic, Item: item}
+	var delivered, skipped int
+	for _, sub := range matched {
+		switch sub.send(msg) {
+		case sent:
+			delivered++
+		case dropped:
+			skipped++
+		case disconnected:
+			skipped++
+			b.Unsubscribe(sub.out)
+		}
+	}
+
+	b.statsMutex.Lock()
+	stats, ok := b.stats[topic]
+	if !ok {
+		stats = &TopicStats{}
+		b.stats[topic] = stats
+	}
+	stats.Published++
+	stats.Delivered += uint64(delivered)
+	stats.Dropped += uint64(skipped)
+	b.statsMutex.Unlock()
+	return delivered, nil
+}
+
+// Subscribe returns a Stream of Messages published on topics matching
+// pattern, buffered with buffer items and governed by policy when full.
+func (b *Bus) Subscribe(pattern string, buffer int, policy SlowPolicy) (Stream, error) {
+	segments, ok := splitTopic(pattern)
+	if !ok || !validPattern(segments) {
+		return nil, InvalidPatternError
+	}
+	sub := &busSubscription{
+		subscriber: newSubscriber(buffer, policy),
+		pattern:    segments,
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		sub.close()
+		return sub.out, nil
+	}
+	b.subscriptions[sub.out] = sub
+	return sub.out, nil
+}
+
+// Unsubscribe closes a Stream returned by Subscribe.
+func (b *Bus) Unsubscribe(s Stream) {
+	b.mutex.Lock()
+	sub, ok := b.subscriptions[s]
+	delete(b.subscriptions, s)
+	b.mutex.Unlock()
+	if ok {
+		sub.close()
+	}
+}
+
+// Stats returns the traffic counters of topic.
+func (b *Bus) Stats(topic string) TopicStats {
+	b.statsMutex.Lock()
+	var stats TopicStats
+	if s, ok := b.stats[topic]; ok {
+		stats = *s
+	}
+	b.statsMutex.Unlock()
+	if segments, ok := splitTopic(topic); ok {
+		b.mutex.RLock()
+		for _, sub := range b.subscriptions {
+			if matchTopic(sub.pattern, segments) {
+				stats.Subscribers++
+			}
+		}
+		b.mutex.RUnlock()
+	}
+	return stats
+}
+
+// Topics returns every topic published on so far, sorted.
+func (b *Bus) Topics() []string {
+	b.statsMutex.Lock()
+	defer b.statsMutex.Unlock()
+	topics := make([]string, 0, len(b.stats))
+	for topic := range b.stats {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Close closes every subscription. Later subscriptions are closed at once.
+func (b *Bus) Close() {
+	b.mutex.Lock()
+	b.closed = true
+	subs := b.subscriptions
+	b.subscriptions = make(map[Stream]*busSubscription)
+	b.mutex.Unlock()
+	for _, sub := range subs {
+		sub.close()
+	}
+}
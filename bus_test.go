@@ -0,0 +1,48 @@
+package stream
+
+import (
+	"testing"
+)
+
+func TestBus_Subscribe(t *testing.T) {
+	bus := NewBus()
+	defer bus.Close()
+	created, _ := bus.Subscribe("orders.*.created", 10, Block)
+	all, _ := bus.Subscribe("orders.>", 10, Block)
+	if _, err := bus.Subscribe("orders.>.created", 10, Block); err != InvalidPatternError {
+		t.Fatalf("expected an invalid pattern, got %v", err)
+	}
+
+	for _, topic := range []string{"orders.eu.created", "orders.eu.deleted", "orders", "users.eu.created"} {
+		if _, err := bus.Publish(topic, topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if msg := (<-created).(Message); msg.Topic != "orders.eu.created" || len(created) != 0 {
+		t.Fatalf("unexpected message %v, %d left", msg, len(created))
+	}
+	if len(all) != 2 {
+		t.Fatalf("expected 2 messages for orders.>, got %d", len(all))
+	}
+	stats := bus.Stats("orders.eu.created")
+	if stats.Published != 1 || stats.Delivered != 2 || stats.Subscribers != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestBus_Drop(t *testing.T) {
+	bus := NewBus()
+	s, _ := bus.Subscribe("metrics", 1, Drop)
+	_, _ = bus.Publish("metrics", 1)
+	_, _ = bus.Publish("metrics", 2)
+	if stats := bus.Stats("metrics"); stats.Delivered != 1 || stats.Dropped != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	bus.Unsubscribe(s)
+	if (<-s).(Message).Item != 1 {
+		t.Fatal("expected the first item to be kept")
+	}
+	if _, ok := <-s; ok {
+		t.Fatal("expected the subscription to be closed")
+	}
+}
@@ -0,0 +1,548 @@
+package stream
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	NoCheckpointSourceError = errors.New("checkpointer has no live source")
+	NoCheckpointSinkError   = errors.New("checkpointer has no sink")
+	NoCheckpointError       = errors.New("no checkpoint found")
+	CheckpointBusyError     = errors.New("too many checkpoints in progress")
+)
+
+const (
+	checkpointPrefix      = "checkpoint-"
+	maxPendingCheckpoints = 16
+)
+
+// Barrier is the marker a Checkpointer sends through a pipeline. Every
+// operator passes it on unchanged, except Consume, which drops it, and
+// First, which closes after its first item; stateful operators created by
+// the Checkpointer record their state as it goes by. Source is the name of
+// the source that emitted it.
+type Barrier struct {
+	ID     int64
+	Source string
+}
+
+func IsBarrier(v interface{}) bool {
+	_, ok := v.(Barrier)
+	return ok
+}
+
+// Checkpoint is a consistent snapshot of a pipeline: how many items each
+// source had emitted and the state of each stateful operator when the
+// barrier passed it. Operator state is stored with encoding/gob, so custom
+// types kept in state must be registered with gob.Register.
+type Checkpoint struct {
+	ID      int64
+	Time    time.Time
+	Offsets map[string]int64
+	State   map[string]interface{}
+}
+
+type pendingCheckpoint struct {
+	checkpoint *Checkpoint
+	sources    map[string]bool
+	acks       map[*checkpointSink]map[string]bool
+	promise    *Promise
+}
+
+// checkpointSink tracks the sources a sink has received barriers from.
+type checkpointSink struct {
+	sources map[string]bool
+	done    bool
+}
+
+type checkpointSource struct {
+	barriers chan Barrier
+	done     bool
+	closed   chan struct{}
+}
+
+// Checkpointer builds checkpointed pipelines. Sources and stateful operators
+// are registered under a name that must stay the same between runs, so that
+// a pipeline rebuilt with NewCheckpointer on the same directory resumes from
+// the latest checkpoint: sources skip the items already accounted for and
+// operators start from their recorded state.
+//
+// Barriers are not aligned across inputs, so a checkpoint is only
+// consistent for pipelines where every sink is fed by a single source.
+// Independent source to sink chains may share a Checkpointer.
+type Checkpointer struct {
+	dir      string
+	nextID   int64
+	sources  map[string]*checkpointSource
+	sinks    []*checkpointSink
+	pending  map[int64]*pendingCheckpoint
+	restored *Checkpoint
+	mutex    sync.Mutex
+}
+
+// NewCheckpointer returns a Checkpointer writing to dir, restored from the
+// latest checkpoint found there.
+func NewCheckpointer(dir string) (*Checkpointer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &Checkpointer{
+		dir:     dir,
+		sources: make(map[string]*checkpointSource),
+		pending: make(map[int64]*pendingCheckpoint),
+	}
+	latest, err := LatestCheckpoint(dir)
+	switch err {
+	case nil:
+		c.restored = latest
+		c.nextID = latest.ID
+	case NoCheckpointError:
+	default:
+		return nil, err
+	}
+	return c, nil
+}
+
+// Restored returns the checkpoint the pipeline resumes from, nil for a fresh start.
+func (c *Checkpointer) Restored() *Checkpoint {
+	return c.restored
+}
+
+// LatestCheckpoint reads the checkpoint with the highest ID in dir.
+func LatestCheckpoint(dir string) (*Checkpoint, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasPrefix(info.Name(), checkpointPrefix) && filepath.Ext(info.Name()) == ".gob" {
+			names = append(names, info.Name())
+		}
+	}
+	if len(names) == 0 {
+		return nil, NoCheckpointError
+	}
+	sort.Strings(names)
+	file, err := os.Open(filepath.Join(dir, names[len(names)-1]))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var checkpoint Checkpoint
+	if err := gob.NewDecoder(file).Decode(&checkpoint); err != nil {
+		return nil, fmt.Errorf("checkpoint %s: %v", file.Name(), err)
+	}
+	return &checkpoint, nil
+}
+
+func (c *Checkpointer) write(checkpoint *Checkpoint) error {
+	name := filepath.Join(c.dir, fmt.Sprintf("%s%020d.gob", checkpointPrefix, checkpoint.ID))
+	file, err := os.Create(name + ".tmp")
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(file).Encode(checkpoint); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+// Checkpoint sends a barrier from every live source. The returned promise
+// resolves with the *Checkpoint once every open sink has seen the barrier
+// and the snapshot is on disk. A sink has seen the barrier once it got it
+// from each live source it received barriers from before, or at least
+// once for its first checkpoint.
+func (c *Checkpointer) Checkpoint() *Promise {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	p, live, err := c.startLocked()
+	if err != nil {
+		return Reject(err)
+	}
+	b := Barrier{ID: p.checkpoint.ID}
+	for _, src := range live {
+		select {
+		case src.barriers <- b:
+		default:
+			delete(c.pending, b.ID)
+			return Reject(CheckpointBusyError)
+		}
+	}
+	return p.promise
+}
+
+// startLocked registers a new pending checkpoint of the live sources, which
+// it returns for the caller to send the barrier to. c.mutex must be held.
+func (c *Checkpointer) startLocked() (*pendingCheckpoint, map[string]*checkpointSource, error) {
+	live := make(map[string]*checkpointSource)
+	for name, src := range c.sources {
+		if !src.done {
+			live[name] = src
+		}
+	}
+	if len(live) == 0 {
+		return nil, nil, NoCheckpointSourceError
+	}
+	open := 0
+	for _, k := range c.sinks {
+		if !k.done {
+			open++
+		}
+	}
+	if open == 0 {
+		return nil, nil, NoCheckpointSinkError
+	}
+	c.nextID++
+	p := &pendingCheckpoint{
+		checkpoint: &Checkpoint{
+			ID:      c.nextID,
+			Offsets: make(map[string]int64),
+			State:   make(map[string]interface{}),
+		},
+		sources: make(map[string]bool),
+		acks:    make(map[*checkpointSink]map[string]bool),
+		promise: NewPromise(nil),
+	}
+	for name := range live {
+		p.sources[name] = true
+	}
+	c.pending[p.checkpoint.ID] = p
+	return p, live, nil
+}
+
+func (c *Checkpointer) restore(name string) (interface{}, bool) {
+	if c.restored == nil {
+		return nil, false
+	}
+	v, ok := c.restored.State[name]
+	return v, ok
+}
+
+func (c *Checkpointer) record(b Barrier, name string, state interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if p, ok := c.pending[b.ID]; ok {
+		p.checkpoint.State[name] = state
+	}
+}
+
+// addSink registers a sink whose barriers a checkpoint waits for.
+func (c *Checkpointer) addSink() *checkpointSink {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	k := &checkpointSink{sources: make(map[string]bool)}
+	c.sinks = append(c.sinks, k)
+	return k
+}
+
+// complete reports whether every open sink has seen the barrier of p.
+// c.mutex must be held.
+func (c *Checkpointer) complete(p *pendingCheckpoint) bool {
+	for _, k := range c.sinks {
+		if k.done {
+			continue
+		}
+		acks := p.acks[k]
+		if len(acks) == 0 {
+			return false
+		}
+		for src := range k.sources {
+			if p.sources[src] && !acks[src] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ack records that sink k received b.
+func (c *Checkpointer) ack(k *checkpointSink, b Barrier) {
+	c.mutex.Lock()
+	k.sources[b.Source] = true
+	p, ok := c.pending[b.ID]
+	if !ok {
+		c.mutex.Unlock()
+		return
+	}
+	if p.acks[k] == nil {
+		p.acks[k] = make(map[string]bool)
+	}
+	p.acks[k][b.Source] = true
+	if !c.complete(p) {
+		c.mutex.Unlock()
+		return
+	}
+	delete(c.pending, b.ID)
+	c.mutex.Unlock()
+	c.finish(p)
+}
+
+// closeSink stops waiting for sink k, whose input has closed, and
+// finishes the checkpoints that were only waiting for it.
+func (c *Checkpointer) closeSink(k *checkpointSink) {
+	c.mutex.Lock()
+	k.done = true
+	done := make([]*pendingCheckpoint, 0)
+	for id, p := range c.pending {
+		if c.complete(p) {
+			delete(c.pending, id)
+			done = append(done, p)
+		}
+	}
+	c.mutex.Unlock()
+	sort.Slice(done, func(i, j int) bool {
+		return done[i].checkpoint.ID < done[j].checkpoint.ID
+	})
+	for _, p := range done {
+		c.finish(p)
+	}
+}
+
+// finish writes the checkpoint of p and settles its promise.
+func (c *Checkpointer) finish(p *pendingCheckpoint) {
+	p.checkpoint.Time = time.Now()
+	if err := c.write(p.checkpoint); err != nil {
+		p.promise.Reject(err)
+		return
+	}
+	p.promise.Resolve(p.checkpoint)
+}
+
+// fail abandons a pending checkpoint, rejecting its promise with err.
+func (c *Checkpointer) fail(b Barrier, err error) {
+	c.mutex.Lock()
+	p, ok := c.pending[b.ID]
+	delete(c.pending, b.ID)
+	c.mutex.Unlock()
+	if ok {
+		p.promise.Reject(err)
+	}
+}
+
+func (c *Checkpointer) promise(b Barrier) *Promise {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if p, ok := c.pending[b.ID]; ok {
+		return p.promise
+	}
+	return nil
+}
+
+// Source registers s as a checkpointed source. s must replay from the
+// beginning on every run: when restoring, the items counted in the
+// checkpoint are skipped. When s closes, a final checkpoint is taken,
+// which records the end of s.
+func (c *Checkpointer) Source(name string, s Stream) Stream {
+	var skip int64
+	if c.restored != nil {
+		skip = c.restored.Offsets[name]
+	}
+	src := &checkpointSource{
+		barriers: make(chan Barrier, maxPendingCheckpoints),
+		closed:   make(chan struct{}),
+	}
+	c.mutex.Lock()
+	c.sources[name] = src
+	c.mutex.Unlock()
+
+	out := make(chan interface{})
+	emitBarrier := func(b Barrier, offset int64) {
+		b.Source = name
+		c.mutex.Lock()
+		if p, ok := c.pending[b.ID]; ok {
+			p.checkpoint.Offsets[name] = offset
+		}
+		c.mutex.Unlock()
+		out <- b
+	}
+	go func() {
+		var offset int64
+	loop:
+		for {
+			select {
+			case v, ok := <-s:
+				if !ok {
+					break loop
+				}
+				offset++
+				if offset <= skip {
+					continue
+				}
+				out <- v
+			case b := <-src.barriers:
+				emitBarrier(b, offset)
+			}
+		}
+		// the final checkpoint records the end offset, so that a run on
+		// the same directory does not send the last items again
+		c.mutex.Lock()
+		final, live, err := c.startLocked()
+		src.done = true
+		c.mutex.Unlock()
+		for drained := false; !drained; {
+			select {
+			case b := <-src.barriers:
+				emitBarrier(b, offset)
+			default:
+				drained = true
+			}
+		}
+		if err == nil {
+			b := Barrier{ID: final.checkpoint.ID}
+			for _, other := range live {
+				if other == src {
+					continue
+				}
+				go func(other *checkpointSource) {
+					select {
+					case other.barriers <- b:
+					case <-other.closed:
+					}
+				}(other)
+			}
+			emitBarrier(b, offset)
+		}
+		close(src.closed)
+		close(out)
+	}()
+	return Stream(out)
+}
+
+// Sink registers the end of a checkpointed pipeline. Barriers are
+// acknowledged and removed; every other item is passed on.
+func (c *Checkpointer) Sink(s Stream) Stream {
+	k := c.addSink()
+	out := make(chan interface{})
+	go func() {
+		for v := range s {
+			if b, ok := v.(Barrier); ok {
+				c.ack(k, b)
+				continue
+			}
+			out <- v
+		}
+		c.closeSink(k)
+		close(out)
+	}()
+	return Stream(out)
+}
+
+// Distinct is Stream.Distinct with its key set checkpointed under name.
+func (c *Checkpointer) Distinct(name string, s Stream, apply KeySelectorFunc) Stream {
+	out := make(chan interface{})
+	go func() {
+		keysets := make(map[interface{}]struct{})
+		if v, ok := c.restore(name); ok {
+			for _, key := range v.([]interface{}) {
+				keysets[key] = struct{}{}
+			}
+		}
+		for item := range s {
+			if b, ok := item.(Barrier); ok {
+				keys := make([]interface{}, 0, len(keysets))
+				for key := range keysets {
+					keys = append(keys, key)
+				}
+				c.record(b, name, keys)
+				out <- item
+				continue
+			}
+			key := apply(item)
+			if _, ok := keysets[key]; !ok {
+				keysets[key] = struct{}{}
+				out <- item
+			}
+		}
+		close(out)
+	}()
+	return Stream(out)
+}
+
+// Scan is Stream.Scan with its accumulator checkpointed under name.
+func (c *Checkpointer) Scan(name string, s Stream, apply ScannableFunc) Stream {
+	out := make(chan interface{})
+	go func() {
+		current, _ := c.restore(name)
+		for item := range s {
+			if b, ok := item.(Barrier); ok {
+				c.record(b, name, current)
+				out <- item
+				continue
+			}
+			current = apply(current, item)
+			out <- current
+		}
+		close(out)
+	}()
+	return Stream(out)
+}
+
+// TakeLast is Stream.TakeLast with its window buffer checkpointed under name.
+// Barriers are passed on immediately rather than held back with the window.
+func (c *Checkpointer) TakeLast(name string, s Stream, nth uint) Stream {
+	out := make(chan interface{})
+	go func() {
+		buf := make([]interface{}, 0, nth)
+		if v, ok := c.restore(name); ok {
+			buf = append(buf, v.([]interface{})...)
+		}
+		for item := range s {
+			if b, ok := item.(Barrier); ok {
+				c.record(b, name, append([]interface{}{}, buf...))
+				out <- item
+				continue
+			}
+			if nth == 0 {
+				continue
+			}
+			if len(buf) >= int(nth) {
+				buf = buf[1:]
+			}
+			buf = append(buf, item)
+		}
+		for _, item := range buf {
+			out <- item
+		}
+		close(out)
+	}()
+	return Stream(out)
+}
+
+// MapWithState is Stream.MapWithState with its keyed state held in memory and
+// checkpointed under name.
+func (c *Checkpointer) MapWithState(name string, s Stream, key KeySelectorFunc, apply StatefulFunc) (Stream, Stream) {
+	store := NewMemoryStateStore()
+	if v, ok := c.restore(name); ok {
+		for k, state := range v.(map[string]interface{}) {
+			_ = store.Put(k, state)
+		}
+	}
+	snapshot := func(b Barrier) {
+		values := make(map[string]interface{})
+		_ = store.Range(func(k string, state interface{}) bool {
+			values[k] = state
+			return true
+		})
+		c.record(b, name, values)
+	}
+	return s.processWithState(key, store, statefulProcess(apply), snapshot)
+}
+
+func init() {
+	gob.Register([]interface{}{})
+	gob.Register(map[string]interface{}{})
+}
@@ -0,0 +1,158 @@
+package stream
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func sum(acc interface{}, v interface{}) interface{} {
+	total, _ := acc.(int)
+	return total + v.(int)
+}
+
+func identity(v interface{}) interface{} {
+	return v
+}
+
+func TestCheckpointer_Restore(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewCheckpointer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := New(0)
+	out := c.Sink(c.Scan("sum", c.Distinct("distinct", c.Source("in", in), identity), sum))
+
+	for _, v := range []int{1, 2, 2, 3} {
+		in <- v
+	}
+	for i := 0; i < 3; i++ {
+		<-out
+	}
+	p := c.Checkpoint()
+	v, err := p.Await()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp := v.(*Checkpoint); cp.Offsets["in"] != 4 || cp.State["sum"] != 6 {
+		t.Fatalf("unexpected checkpoint %+v", cp)
+	}
+	close(in)
+	for range out {
+	}
+
+	c, err = NewCheckpointer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = c.Sink(c.Scan("sum", c.Distinct("distinct", c.Source("in", Just(1, 2, 2, 3, 3, 4)), identity), sum))
+	results := make([]interface{}, 0)
+	for v := range out {
+		results = append(results, v)
+	}
+	if len(results) != 1 || results[0] != 10 {
+		t.Fatalf("expected the restored pipeline to emit only 10, got %v", results)
+	}
+}
+
+func TestCheckpointer_IndependentChains(t *testing.T) {
+	c, err := NewCheckpointer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := New(0), New(0)
+	outA := c.Sink(c.Scan("sumA", c.Source("a", a), sum))
+	outB := c.Sink(c.Scan("sumB", c.Source("b", b), sum))
+	a <- 1
+	<-outA
+	b <- 2
+	<-outB
+
+	for i := 0; i < 2; i++ {
+		done := make(chan interface{})
+		go func() {
+			v, err := c.Checkpoint().Await()
+			if err != nil {
+				t.Error(err)
+			}
+			done <- v
+		}()
+		select {
+		case v := <-done:
+			if cp := v.(*Checkpoint); cp.State["sumA"] != 1 || cp.State["sumB"] != 2 {
+				t.Fatalf("unexpected checkpoint %+v", cp)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("checkpoint of two independent chains never completed")
+		}
+	}
+	close(a)
+	for range outA {
+	}
+	v, err := c.Checkpoint().Await()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp := v.(*Checkpoint); cp.Offsets["b"] != 1 {
+		t.Fatalf("unexpected checkpoint %+v", cp)
+	}
+	close(b)
+	for range outB {
+	}
+}
+
+func TestBarrier_PassThrough(t *testing.T) {
+	b := Barrier{ID: 1}
+	collect := func(s Stream) []interface{} {
+		items := make([]interface{}, 0)
+		for v := range s {
+			items = append(items, v)
+		}
+		return items
+	}
+	cases := map[string]struct {
+		out      Stream
+		expected []interface{}
+	}{
+		"TakeLast": {Just(1, b, 2).TakeLast(1), []interface{}{b, 2}},
+		"SkipLast": {Just(1, b, 2).SkipLast(1), []interface{}{b, 1}},
+		"First":    {Just(b, 1, 2).First(), []interface{}{b, 1}},
+	}
+	for name, c := range cases {
+		if items := collect(c.out); !reflect.DeepEqual(items, c.expected) {
+			t.Errorf("%s: expected %v, got %v", name, c.expected, items)
+		}
+	}
+
+	odd, dead := New(3), New(3)
+	Just(1, b, 2).Classify(func(v interface{}) string {
+		if v.(int)%2 == 1 {
+			return "odd"
+		}
+		return ""
+	}, map[string]Stream{"odd": odd}, dead)
+	if v := <-odd; v != 1 {
+		t.Fatalf("expected 1, got %v", v)
+	}
+	if v := <-odd; v != b {
+		t.Fatalf("expected the barrier on the odd handler, got %v", v)
+	}
+	if v := <-dead; v != b {
+		t.Fatalf("expected the barrier on the dead handler, got %v", v)
+	}
+
+	consumed := make([]interface{}, 0)
+	done := make(chan struct{})
+	in := Just(b, 1)
+	in.Consume(func(v interface{}) {
+		consumed = append(consumed, v)
+		if len(consumed) == 1 {
+			close(done)
+		}
+	})
+	<-done
+	if consumed[0] != 1 {
+		t.Fatalf("expected Consume to skip the barrier, got %v", consumed)
+	}
+}
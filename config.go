@@ -0,0 +1,395 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// HandlerLookup resolves the handler, hook and middleware names used in a
+// pipeline configuration. A handler may be anything a Streams chain accepts.
+type HandlerLookup interface {
+	Handler(name string) (interface{}, bool)
+	Hook(name string) (HandleFunc, bool)
+	Middleware(name string) (Middleware, bool)
+}
+
+// HandlerMap is a HandlerLookup over a plain map. A name can be used as a
+// hook only when its value is a HandleFunc or a func of the same shape, and
+// as middleware only when its value is a Middleware.
+type HandlerMap map[string]interface{}
+
+func (m HandlerMap) Handler(name string) (interface{}, bool) {
+	h, ok := m[name]
+	return h, ok
+}
+
+func (m HandlerMap) Hook(name string) (HandleFunc, bool) {
+	switch h := m[name].(type) {
+	case HandleFunc:
+		return h, true
+	case func(interface{}) (interface{}, error):
+		return h, true
+	}
+	return nil, false
+}
+
+func (m HandlerMap) Middleware(name string) (Middleware, bool) {
+	switch mw := m[name].(type) {
+	case Middleware:
+		return mw, true
+	case func(HandleFunc) HandleFunc:
+		return mw, true
+	}
+	return nil, false
+}
+
+// ConfigError is a problem found at a position in a pipeline configuration.
+type ConfigError struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *ConfigError) Error() string {
+	msg := e.Msg
+	if e.Line > 0 {
+		msg = fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, msg)
+	}
+	if e.File != "" {
+		msg = fmt.Sprintf("%s: %s", e.File, msg)
+	}
+	return msg
+}
+
+// ConfigErrors is every ConfigError found in one configuration.
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// nameAt is a name read from the configuration and where it was found.
+type nameAt struct {
+	name string
+	at   *yaml.Node
+}
+
+type nodeConfig struct {
+	name       string
+	handlers   []nameAt
+	before     string
+	after      string
+	buffer     int
+	middleware []nameAt
+	pipes      []nameAt
+	errors     string
+	pos        map[string]*yaml.Node
+}
+
+type configParser struct {
+	errs ConfigErrors
+}
+
+func (p *configParser) errorf(at *yaml.Node, format string, args ...interface{}) {
+	err := &ConfigError{Msg: fmt.Sprintf(format, args...)}
+	if at != nil {
+		err.Line, err.Column = at.Line, at.Column
+	}
+	p.errs = append(p.errs, err)
+}
+
+func (p *configParser) decode(value *yaml.Node, out interface{}, field string) {
+	if err := value.Decode(out); err != nil {
+		p.errorf(value, "invalid %s: expected %s", field, kindName(out))
+	}
+}
+
+func kindName(out interface{}) string {
+	switch out.(type) {
+	case *string:
+		return "a string"
+	case *int:
+		return "an integer"
+	}
+	return fmt.Sprintf("%T", out)
+}
+
+// decodeNames accepts either a single name or a list of names.
+func (p *configParser) decodeNames(value *yaml.Node, field string) []nameAt {
+	items := []*yaml.Node{value}
+	if value.Kind == yaml.SequenceNode {
+		items = value.Content
+	}
+	names := make([]nameAt, 0, len(items))
+	for _, item := range items {
+		var name string
+		if item.Kind != yaml.ScalarNode || item.Decode(&name) != nil {
+			p.errorf(item, "invalid %s: expected a name or a list of names", field)
+			continue
+		}
+		names = append(names, nameAt{name: name, at: item})
+	}
+	return names
+}
+
+func (p *configParser) parseNode(n *yaml.Node) *nodeConfig {
+	if n.Kind != yaml.MappingNode {
+		p.errorf(n, "node must be a mapping")
+		return nil
+	}
+	cfg := &nodeConfig{pos: make(map[string]*yaml.Node)}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		cfg.pos[key.Value] = value
+		switch key.Value {
+		case "name":
+			p.decode(value, &cfg.name, "name")
+		case "handler", "handlers":
+			cfg.handlers = append(cfg.handlers, p.decodeNames(value, key.Value)...)
+		case "before":
+			p.decode(value, &cfg.before, "before")
+		case "after":
+			p.decode(value, &cfg.after, "after")
+		case "buffer":
+			p.decode(value, &cfg.buffer, "buffer")
+			if cfg.buffer < 0 {
+				p.errorf(value, "buffer must not be negative")
+			}
+		case "middleware":
+			cfg.middleware = p.decodeNames(value, "middleware")
+		case "pipes":
+			cfg.pipes = p.decodeNames(value, "pipes")
+		case "errors":
+			p.decode(value, &cfg.errors, "errors")
+		default:
+			p.errorf(key, "unknown field %q", key.Value)
+		}
+	}
+	if cfg.name == "" {
+		p.errorf(n, "node has no name")
+	}
+	if len(cfg.handlers) == 0 {
+		p.errorf(n, "node %q has no handler", cfg.name)
+	}
+	return cfg
+}
+
+func (p *configParser) parse(data []byte) []*nodeConfig {
+	// JSON is read as YAML, which does not allow tabs for indentation.
+	// Valid JSON has no raw tabs inside strings, so this keeps positions.
+	data = bytes.ReplaceAll(data, []byte("\t"), []byte(" "))
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		p.errs = append(p.errs, yamlError(err))
+		return nil
+	}
+	if len(doc.Content) == 0 {
+		p.errorf(nil, "empty configuration")
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		p.errorf(root, "configuration must be a mapping")
+		return nil
+	}
+	var nodes *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case "nodes":
+			nodes = value
+		default:
+			p.errorf(key, "unknown field %q", key.Value)
+		}
+	}
+	if nodes == nil {
+		p.errorf(root, "configuration has no nodes")
+		return nil
+	}
+	if nodes.Kind != yaml.SequenceNode {
+		p.errorf(nodes, "nodes must be a list")
+		return nil
+	}
+	configs := make([]*nodeConfig, 0, len(nodes.Content))
+	for _, n := range nodes.Content {
+		if cfg := p.parseNode(n); cfg != nil {
+			configs = append(configs, cfg)
+		}
+	}
+	return configs
+}
+
+// yamlError turns a parse error into a ConfigError, keeping the line the
+// parser reports in its message.
+func yamlError(err error) *ConfigError {
+	var line int
+	msg := strings.TrimPrefix(err.Error(), "yaml: ")
+	if _, scanErr := fmt.Sscanf(msg, "line %d:", &line); scanErr == nil {
+		msg = strings.TrimSpace(strings.TrimPrefix(msg, fmt.Sprintf("line %d:", line)))
+		return &ConfigError{Line: line, Column: 1, Msg: msg}
+	}
+	return &ConfigError{Msg: msg}
+}
+
+func (p *configParser) hook(cfg *nodeConfig, field string, name string, handlers HandlerLookup) HandleFunc {
+	if name == "" {
+		return nil
+	}
+	hook, ok := handlers.Hook(name)
+	if !ok {
+		p.errorf(cfg.pos[field], "unknown hook %q", name)
+	}
+	return hook
+}
+
+func (p *configParser) middleware(cfg *nodeConfig, handlers HandlerLookup) []Middleware {
+	mws := make([]Middleware, 0, len(cfg.middleware))
+	for _, ref := range cfg.middleware {
+		mw, ok := handlers.Middleware(ref.name)
+		if !ok {
+			p.errorf(ref.at, "unknown middleware %q", ref.name)
+			continue
+		}
+		mws = append(mws, mw)
+	}
+	return mws
+}
+
+func (p *configParser) handler(ref nameAt, before, after HandleFunc, handlers HandlerLookup) interface{} {
+	name, at := ref.name, ref.at
+	h, ok := handlers.Handler(name)
+	if !ok {
+		p.errorf(at, "unknown handler %q", name)
+		return nil
+	}
+	if before == nil && after == nil {
+		return h
+	}
+	var handler Handler
+	switch fn := h.(type) {
+	case *Handler:
+		handler = *fn
+	case HandleFunc:
+		handler = Handler{Name: name, Apply: fn}
+	case func(interface{}) (interface{}, error):
+		handler = Handler{Name: name, Apply: fn}
+	default:
+		p.errorf(at, "handler %q does not take before/after hooks", name)
+		return nil
+	}
+	if before != nil {
+		handler.Before = before
+	}
+	if after != nil {
+		handler.After = after
+	}
+	return &handler
+}
+
+// ParseGraph reads a pipeline configuration in YAML or JSON and returns the
+// Graph it declares. The configuration lists named nodes:
+//
+//	nodes:
+//	  - name: parse
+//	    handler: parseOrder   # or handlers: [parseOrder, validate]
+//	    before: logIn         # hooks, applied to every handler of the node
+//	    after: logOut
+//	    buffer: 100
+//	    middleware: [recover] # applied to every handler, the first outermost
+//	    pipes: [enrich]
+//	    errors: failed        # node receiving the failures
+//
+// Handler, hook and middleware names are resolved with handlers. Every problem found is
+// reported in a ConfigErrors with its line and column.
+func ParseGraph(data []byte, handlers HandlerLookup) (*Graph, error) {
+	p := &configParser{}
+	configs := p.parse(data)
+
+	declared := make(map[string]*nodeConfig)
+	for _, cfg := range configs {
+		if cfg.name == "" {
+			continue
+		}
+		if _, ok := declared[cfg.name]; ok {
+			p.errorf(cfg.pos["name"], "duplicate node %q", cfg.name)
+			continue
+		}
+		declared[cfg.name] = cfg
+	}
+
+	g := NewGraph()
+	for _, cfg := range configs {
+		if declared[cfg.name] != cfg {
+			continue
+		}
+		before := p.hook(cfg, "before", cfg.before, handlers)
+		after := p.hook(cfg, "after", cfg.after, handlers)
+		handles := make([]interface{}, 0, len(cfg.handlers))
+		for _, ref := range cfg.handlers {
+			if h := p.handler(ref, before, after, handlers); h != nil {
+				handles = append(handles, h)
+			}
+		}
+		g.AddNode(cfg.name, handles).SetBuffer(cfg.name, cfg.buffer)
+		if mws := p.middleware(cfg, handlers); len(mws) > 0 {
+			g.Use(cfg.name, mws...)
+		}
+	}
+	for _, cfg := range configs {
+		if declared[cfg.name] != cfg {
+			continue
+		}
+		for _, to := range cfg.pipes {
+			if _, ok := declared[to.name]; !ok {
+				p.errorf(to.at, "pipe to unknown node %q", to.name)
+				continue
+			}
+			g.Connect(cfg.name, to.name)
+		}
+		if cfg.errors != "" {
+			if _, ok := declared[cfg.errors]; !ok {
+				p.errorf(cfg.pos["errors"], "errors routed to unknown node %q", cfg.errors)
+				continue
+			}
+			g.ConnectErrors(cfg.name, cfg.errors)
+		}
+	}
+	if len(p.errs) > 0 {
+		return nil, p.errs
+	}
+	return g, nil
+}
+
+// LoadNetwork parses a pipeline configuration and builds it.
+func LoadNetwork(ctx context.Context, data []byte, handlers HandlerLookup) (*Network, error) {
+	g, err := ParseGraph(data, handlers)
+	if err != nil {
+		return nil, err
+	}
+	return g.Build(ctx)
+}
+
+// LoadNetworkFile is LoadNetwork reading the configuration from path.
+func LoadNetworkFile(ctx context.Context, path string, handlers HandlerLookup) (*Network, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	n, err := LoadNetwork(ctx, data, handlers)
+	if errs, ok := err.(ConfigErrors); ok {
+		for _, e := range errs {
+			e.File = path
+		}
+	}
+	return n, err
+}
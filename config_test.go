@@ -0,0 +1,119 @@
+package stream
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestLoadNetwork(t *testing.T) {
+	out := New(10)
+	failed := New(10)
+	handlers := HandlerMap{
+		"parse": func(v interface{}) (interface{}, error) {
+			if v == "" {
+				return nil, InputNotASliceError
+			}
+			return strings.ToUpper(v.(string)), nil
+		},
+		"exclaim": HandleFunc(func(v interface{}) (interface{}, error) {
+			return v.(string) + "!", nil
+		}),
+		"print": func(v interface{}) (interface{}, error) {
+			out <- v
+			return v, nil
+		},
+		"failed": func(v interface{}) (interface{}, error) {
+			failed <- v
+			return v, nil
+		},
+	}
+	config := `{
+	"nodes": [
+		{"name": "in", "handler": "parse", "after": "exclaim", "buffer": 10, "pipes": ["out"], "errors": "failed"},
+		{"name": "out", "handler": "print"},
+		{"name": "failed", "handler": "failed"}
+	]
+}`
+	n, err := LoadNetwork(context.Background(), []byte(config), handlers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	n.Input("in") <- "hello"
+	if v := <-out; v != "HELLO!" {
+		t.Fatalf("expected HELLO!, got %v", v)
+	}
+	n.Input("in") <- ""
+	if f := (<-failed).(Failure); f.Item != "" || f.Err != InputNotASliceError {
+		t.Fatalf("expected the failure to be routed, got %v", f)
+	}
+}
+
+func TestLoadNetwork_Middleware(t *testing.T) {
+	out := New(10)
+	r := NewRegistry()
+	if err := r.RegisterHandler("print", func(v interface{}) (interface{}, error) {
+		out <- v
+		return v, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	wrap := func(mark string) Middleware {
+		return func(next HandleFunc) HandleFunc {
+			return func(v interface{}) (interface{}, error) {
+				return next(v.(string) + mark)
+			}
+		}
+	}
+	if err := r.RegisterMiddleware("open", wrap("(")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RegisterMiddleware("close", wrap(")")); err != nil {
+		t.Fatal(err)
+	}
+	config := `
+nodes:
+  - name: in
+    handler: print
+    middleware: [open, close]
+`
+	n, err := LoadNetwork(context.Background(), []byte(config), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	n.Input("in") <- "x"
+	if v := <-out; v != "x()" {
+		t.Fatalf("expected x(), got %v", v)
+	}
+}
+
+func TestParseGraph_Errors(t *testing.T) {
+	config := `
+nodes:
+  - name: in
+    handler: parse
+    pipes: [out, nowhere]
+  - name: out
+    handler: [missing]
+    buffer: lots
+    middleware: [nothing]
+`
+	_, err := ParseGraph([]byte(config), HandlerMap{"parse": HandleFunc(nil)})
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatalf("expected ConfigErrors, got %v", err)
+	}
+	expected := []string{
+		`line 7, column 15: unknown handler "missing"`,
+		`line 8, column 13: invalid buffer: expected an integer`,
+		`line 9, column 18: unknown middleware "nothing"`,
+		`line 5, column 18: pipe to unknown node "nowhere"`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(errs.Error(), e) {
+			t.Errorf("expected %q in\n%v", e, errs)
+		}
+	}
+}
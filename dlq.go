@@ -0,0 +1,370 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	UnclassifiedError   = errors.New("item matched no classification")
+	DeadLetterNotFound  = errors.New("dead letter not found")
+	NoReplayTargetError = errors.New("replay target has no input")
+)
+
+// DeadLetter is an item that failed processing, together with why, where and
+// how often it failed.
+type DeadLetter struct {
+	ID       int64       `json:"id"`
+	Item     interface{} `json:"item"`
+	Err      error       `json:"-"`
+	Reason   string      `json:"error"`
+	Handler  string      `json:"handler"`
+	Attempts int         `json:"attempts"`
+	Time     time.Time   `json:"time"`
+}
+
+// DeadLetterFilter selects dead letters for List and Replay.
+// A nil filter selects everything.
+type DeadLetterFilter func(*DeadLetter) bool
+
+// ByHandler selects the dead letters recorded for the named handler.
+func ByHandler(name string) DeadLetterFilter {
+	return func(l *DeadLetter) bool {
+		return l.Handler == name
+	}
+}
+
+// ByReason selects the dead letters whose error message contains substr.
+func ByReason(substr string) DeadLetterFilter {
+	return func(l *DeadLetter) bool {
+		return strings.Contains(l.Reason, substr)
+	}
+}
+
+// RecordedSince selects the dead letters recorded at or after t.
+func RecordedSince(t time.Time) DeadLetterFilter {
+	return func(l *DeadLetter) bool {
+		return !l.Time.Before(t)
+	}
+}
+
+// DeadLetterStore persists dead letters. Put inserts or replaces by ID.
+type DeadLetterStore interface {
+	Put(letter *DeadLetter) error
+	Delete(id int64) error
+	List() ([]*DeadLetter, error)
+}
+
+// MemoryDeadLetterStore keeps dead letters in memory.
+type MemoryDeadLetterStore struct {
+	letters map[int64]*DeadLetter
+	mutex   sync.Mutex
+}
+
+func NewMemoryDeadLetterStore() *MemoryDeadLetterStore {
+	return &MemoryDeadLetterStore{
+		letters: make(map[int64]*DeadLetter),
+	}
+}
+
+func (m *MemoryDeadLetterStore) Put(letter *DeadLetter) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.letters[letter.ID] = letter
+	return nil
+}
+
+func (m *MemoryDeadLetterStore) Delete(id int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.letters[id]; !ok {
+		return DeadLetterNotFound
+	}
+	delete(m.letters, id)
+	return nil
+}
+
+func (m *MemoryDeadLetterStore) List() ([]*DeadLetter, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	letters := make([]*DeadLetter, 0, len(m.letters))
+	for _, l := range m.letters {
+		letters = append(letters, l)
+	}
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].ID < letters[j].ID
+	})
+	return letters, nil
+}
+
+// FileDeadLetterStore keeps dead letters in memory and mirrors them to a file,
+// one JSON object per line. Items come back from the file as decoded JSON
+// values, and errors as plain errors carrying the original message.
+type FileDeadLetterStore struct {
+	*MemoryDeadLetterStore
+	path  string
+	write sync.Mutex
+}
+
+func NewFileDeadLetterStore(path string) (*FileDeadLetterStore, error) {
+	f := &FileDeadLetterStore{
+		MemoryDeadLetterStore: NewMemoryDeadLetterStore(),
+		path:                  path,
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var l DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			return nil, err
+		}
+		l.Err = errors.New(l.Reason)
+		f.letters[l.ID] = &l
+	}
+	return f, scanner.Err()
+}
+
+func (f *FileDeadLetterStore) Put(letter *DeadLetter) error {
+	_ = f.MemoryDeadLetterStore.Put(letter)
+	return f.flush()
+}
+
+func (f *FileDeadLetterStore) Delete(id int64) error {
+	if err := f.MemoryDeadLetterStore.Delete(id); err != nil {
+		return err
+	}
+	return f.flush()
+}
+
+// flush replaces the file with the letters in memory. Flushes are
+// serialized so they do not share the temporary file, and each one lists
+// the letters after the previous one is done, so the last one has the
+// latest state.
+func (f *FileDeadLetterStore) flush() error {
+	f.write.Lock()
+	defer f.write.Unlock()
+	letters, _ := f.List()
+	tmp := f.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
+	for _, l := range letters {
+		if err := enc.Encode(l); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// DeadLetterQueue records items that failed in a Stream or Streams chain and
+// lets them be inspected and replayed. An item that fails again after a
+// replay is recorded with its attempt count increased. Only the last
+// maxReplayedLetters replayed letters are remembered for that.
+type DeadLetterQueue struct {
+	store       DeadLetterStore
+	nextID      int64
+	replayed    []*DeadLetter
+	maxReplayed int
+	mutex       sync.Mutex
+}
+
+const maxReplayedLetters = 1024
+
+// NewDeadLetterQueue returns a queue backed by store, or by a
+// MemoryDeadLetterStore when store is nil.
+func NewDeadLetterQueue(store DeadLetterStore) *DeadLetterQueue {
+	if store == nil {
+		store = NewMemoryDeadLetterStore()
+	}
+	q := &DeadLetterQueue{store: store, maxReplayed: maxReplayedLetters}
+	if letters, err := store.List(); err == nil {
+		for _, l := range letters {
+			if l.ID > q.nextID {
+				q.nextID = l.ID
+			}
+		}
+	}
+	return q
+}
+
+// Record stores a failed item. handler is anything HandlerName understands:
+// a *Handler, a HandleFunc or simply a name.
+func (q *DeadLetterQueue) Record(item interface{}, err error, handler interface{}) (*DeadLetter, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	name := HandlerName(handler)
+	attempts := 1
+	for i, l := range q.replayed {
+		if l.Handler == name && reflect.DeepEqual(l.Item, item) {
+			attempts = l.Attempts + 1
+			q.replayed = append(q.replayed[:i], q.replayed[i+1:]...)
+			break
+		}
+	}
+	q.nextID++
+	letter := &DeadLetter{
+		ID:       q.nextID,
+		Item:     item,
+		Err:      err,
+		Handler:  name,
+		Attempts: attempts,
+		Time:     time.Now(),
+	}
+	if err != nil {
+		letter.Reason = err.Error()
+	}
+	return letter, q.store.Put(letter)
+}
+
+// List returns the recorded dead letters selected by filter, oldest first.
+func (q *DeadLetterQueue) List(filter DeadLetterFilter) ([]*DeadLetter, error) {
+	letters, err := q.store.List()
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		return letters, nil
+	}
+	selected := make([]*DeadLetter, 0)
+	for _, l := range letters {
+		if filter(l) {
+			selected = append(selected, l)
+		}
+	}
+	return selected, nil
+}
+
+// Remove drops a dead letter without replaying it.
+func (q *DeadLetterQueue) Remove(id int64) error {
+	return q.store.Delete(id)
+}
+
+// Replay sends the items of the dead letters selected by filter to the
+// target's Input, or resolves them directly when the target has no input
+// channel, and removes each letter once its item was handed over. It stops
+// at the first item that cannot be sent, because ctx ended or the target no
+// longer takes input, leaving that letter and the next ones in the queue.
+// It returns the number of items replayed.
+func (q *DeadLetterQueue) Replay(ctx context.Context, target Streams, filter DeadLetterFilter) (int, error) {
+	if target == nil {
+		return 0, NoReplayTargetError
+	}
+	letters, err := q.List(filter)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, l := range letters {
+		// remembered before the item can fail again
+		q.remember(l)
+		if in := target.Input(); in != nil {
+			err = sendTo(ctx, in, l.Item)
+		} else {
+			target.Resolve(l.Item)
+		}
+		if err != nil {
+			q.forget(l)
+			return n, err
+		}
+		if err := q.store.Delete(l.ID); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// remember keeps l to count the attempts of its item if it fails again.
+func (q *DeadLetterQueue) remember(l *DeadLetter) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.replayed = append(q.replayed, l)
+	if n := len(q.replayed) - q.maxReplayed; n > 0 {
+		copy(q.replayed, q.replayed[n:])
+		for i := len(q.replayed) - n; i < len(q.replayed); i++ {
+			q.replayed[i] = nil
+		}
+		q.replayed = q.replayed[:len(q.replayed)-n]
+	}
+}
+
+func (q *DeadLetterQueue) forget(l *DeadLetter) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for i, r := range q.replayed {
+		if r == l {
+			q.replayed = append(q.replayed[:i], q.replayed[i+1:]...)
+			return
+		}
+	}
+}
+
+// Sink returns a Stream that records everything sent to it for handler. A
+// Failure, as sent to the Errors of a Route, is recorded as its item with
+// its error; anything else as unclassified, which makes the Stream fit as
+// the deadHandler of Classify.
+func (q *DeadLetterQueue) Sink(handler interface{}) Stream {
+	in := New(0)
+	go func() {
+		for v := range in {
+			if f, ok := v.(Failure); ok {
+				_, _ = q.Record(f.Item, f.Err, handler)
+				continue
+			}
+			_, _ = q.Record(v, UnclassifiedError, handler)
+		}
+	}()
+	return in
+}
+
+// HandleDeadLetter works like Handle but records failed items in q instead of
+// sending the bare errors to a separate Stream.
+func (s Stream) HandleDeadLetter(apply HandleFunc, q *DeadLetterQueue) Stream {
+	out := make(chan interface{})
+	go func() {
+		for v := range s {
+			if IsBarrier(v) {
+				out <- v
+				continue
+			}
+			res, err := apply(v)
+			if err != nil {
+				_, _ = q.Record(v, err, apply)
+				continue
+			}
+			out <- res
+		}
+		close(out)
+	}()
+	return Stream(out)
+}
@@ -0,0 +1,194 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func failOdd(v interface{}) (interface{}, error) {
+	if v.(int)%2 == 1 {
+		return nil, errors.New("odd")
+	}
+	return v, nil
+}
+
+func TestDeadLetterQueue_Handle(t *testing.T) {
+	q := NewDeadLetterQueue(nil)
+	n := 0
+	for range Range(0, 10).HandleDeadLetter(failOdd, q) {
+		n++
+	}
+	if n != 5 {
+		t.Fatalf("expected 5 items to pass, got %d", n)
+	}
+	letters, _ := q.List(ByHandler(HandlerName(failOdd)))
+	if len(letters) != 5 {
+		t.Fatalf("expected 5 dead letters, got %d", len(letters))
+	}
+	if letters[0].Item != 1 || letters[0].Reason != "odd" || letters[0].Attempts != 1 {
+		t.Fatalf("unexpected dead letter %+v", letters[0])
+	}
+}
+
+func TestDeadLetterQueue_Replay(t *testing.T) {
+	q := NewDeadLetterQueue(nil)
+	_, _ = q.Record(3, errors.New("odd"), "retry")
+	_, _ = q.Record(4, errors.New("even"), "retry")
+
+	replayed := make(chan interface{}, 2)
+	target := With("retry", context.Background(), 10, func(v interface{}) (interface{}, error) {
+		replayed <- v
+		return v, nil
+	})
+	defer target.Close()
+
+	n, err := q.Replay(context.Background(), target, ByReason("odd"))
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 replayed item, got %d %v", n, err)
+	}
+	select {
+	case v := <-replayed:
+		if v != 3 {
+			t.Fatalf("expected 3 to be replayed, got %v", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("replayed item never reached the target")
+	}
+
+	letter, _ := q.Record(3, errors.New("odd"), "retry")
+	if letter.Attempts != 2 {
+		t.Fatalf("expected a second attempt, got %d", letter.Attempts)
+	}
+}
+
+func TestFileDeadLetterStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dlq.jsonl")
+	store, err := NewFileDeadLetterStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := NewDeadLetterQueue(store)
+	_, _ = q.Record("a", errors.New("bad"), "parse")
+
+	store, err = NewFileDeadLetterStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	letters, _ := NewDeadLetterQueue(store).List(nil)
+	if len(letters) != 1 || letters[0].Item != "a" || letters[0].Err.Error() != "bad" {
+		t.Fatalf("unexpected dead letters %+v", letters)
+	}
+}
+
+func TestDeadLetterQueue_ReplayedBound(t *testing.T) {
+	q := NewDeadLetterQueue(nil)
+	q.maxReplayed = 2
+	for i := 0; i < 3; i++ {
+		_, _ = q.Record(i, errors.New("bad"), "retry")
+	}
+	target := With("retry", context.Background(), 10, func(v interface{}) (interface{}, error) {
+		return v, nil
+	})
+	defer target.Close()
+	if n, err := q.Replay(context.Background(), target, nil); err != nil || n != 3 {
+		t.Fatalf("expected 3 replayed items, got %d %v", n, err)
+	}
+	if len(q.replayed) != 2 {
+		t.Fatalf("expected 2 remembered letters, got %d", len(q.replayed))
+	}
+	if letter, _ := q.Record(0, errors.New("bad"), "retry"); letter.Attempts != 1 {
+		t.Fatalf("expected the oldest replay to be forgotten, got %d attempts", letter.Attempts)
+	}
+	if letter, _ := q.Record(2, errors.New("bad"), "retry"); letter.Attempts != 2 {
+		t.Fatalf("expected a second attempt, got %d", letter.Attempts)
+	}
+}
+
+func TestFileDeadLetterStore_Concurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dlq.jsonl")
+	store, err := NewFileDeadLetterStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := NewDeadLetterQueue(store)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := q.Record(i, errors.New("bad"), "parse"); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	store, err = NewFileDeadLetterStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	letters, _ := NewDeadLetterQueue(store).List(nil)
+	if len(letters) != 20 {
+		t.Fatalf("expected 20 dead letters on disk, got %d", len(letters))
+	}
+}
+
+func TestDeadLetterQueue_ReplayFailed(t *testing.T) {
+	q := NewDeadLetterQueue(nil)
+	_, _ = q.Record(1, errors.New("bad"), "retry")
+
+	closed := With("retry", context.Background(), 0, failOdd)
+	if err := closed.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := q.Replay(context.Background(), closed, nil); n != 0 || err != StreamsClosedError {
+		t.Fatalf("expected StreamsClosedError, got %d %v", n, err)
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	stalled := With("retry", context.Background(), 0, func(v interface{}) (interface{}, error) {
+		<-release
+		return v, nil
+	})
+	stalled.Input() <- 0
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if n, err := q.Replay(ctx, stalled, nil); n != 0 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected DeadlineExceeded, got %d %v", n, err)
+	}
+
+	if letters, _ := q.List(nil); len(letters) != 1 {
+		t.Fatalf("expected the letter to stay in the queue, got %v", letters)
+	}
+	if letter, _ := q.Record(1, errors.New("bad"), "retry"); letter.Attempts != 1 {
+		t.Fatalf("expected a failed replay not to count as an attempt, got %d", letter.Attempts)
+	}
+}
+
+func TestDeadLetterQueue_SinkFailure(t *testing.T) {
+	q := NewDeadLetterQueue(nil)
+	failures := q.Sink("parse")
+	failures <- Failure{Item: "x", Err: errors.New("bad input")}
+	failures <- "y"
+	close(failures)
+
+	var letters []*DeadLetter
+	for i := 0; i < 100 && len(letters) < 2; i++ {
+		time.Sleep(time.Millisecond)
+		letters, _ = q.List(nil)
+	}
+	if len(letters) != 2 {
+		t.Fatalf("expected 2 dead letters, got %d", len(letters))
+	}
+	if letters[0].Item != "x" || letters[0].Reason != "bad input" {
+		t.Fatalf("expected the failure to be unwrapped, got %+v", letters[0])
+	}
+	if letters[1].Item != "y" || letters[1].Err != UnclassifiedError {
+		t.Fatalf("expected an unclassified item, got %+v", letters[1])
+	}
+}
@@ -0,0 +1,194 @@
+package stream
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	ExecutorQueueFullError = errors.New("executor queue is full")
+	ExecutorShutdownError  = errors.New("executor is shut down")
+)
+
+// RejectionPolicy tells what an Executor does with a task when all its
+// workers are busy and its queue is full.
+type RejectionPolicy int
+
+const (
+	// RejectNew rejects the new task with ExecutorQueueFullError.
+	RejectNew RejectionPolicy = iota
+	// DiscardOldest rejects the oldest queued task with
+	// ExecutorQueueFullError and queues the new one.
+	DiscardOldest
+	// CallerRuns runs the new task in the goroutine calling Go.
+	CallerRuns
+)
+
+// Task is a unit of work run by an Executor.
+type Task func() (interface{}, error)
+
+// ExecutorStats is a snapshot of the activity of an Executor.
+type ExecutorStats struct {
+	Running   int
+	Queued    int
+	Completed uint64
+	Rejected  uint64
+}
+
+type queuedTask struct {
+	task    Task
+	promise *Promise
+}
+
+// Executor runs tasks on at most maxConcurrency goroutines, queueing up to
+// queueLimit more. A negative queueLimit does not bound the queue; a zero
+// one queues nothing. Goroutines are only started while there is work.
+type Executor struct {
+	maxConcurrency int
+	queueLimit     int
+	policy         RejectionPolicy
+	queue          []*queuedTask
+	running        int
+	completed      uint64
+	rejected       uint64
+	shutdown       bool
+	idle           *sync.Cond
+	mutex          sync.Mutex
+}
+
+func NewExecutor(maxConcurrency, queueLimit int, policy RejectionPolicy) *Executor {
+	if maxConcurrency < 1 {
+		maxConcurrency = 1
+	}
+	e := &Executor{
+		maxConcurrency: maxConcurrency,
+		queueLimit:     queueLimit,
+		policy:         policy,
+		queue:          make([]*queuedTask, 0),
+	}
+	e.idle = sync.NewCond(&e.mutex)
+	return e
+}
+
+// Go runs task as soon as a worker is free and returns a promise of its
+// result. A panic of task rejects the promise with a *PanicError.
+func (e *Executor) Go(task Task) *Promise {
+	t := &queuedTask{task: task, promise: NewPromise(nil)}
+	e.mutex.Lock()
+	switch {
+	case e.shutdown:
+		e.rejected++
+		e.mutex.Unlock()
+		t.promise.Reject(ExecutorShutdownError)
+	case e.running < e.maxConcurrency:
+		e.running++
+		e.mutex.Unlock()
+		go e.work(t)
+	case e.queueLimit < 0 || len(e.queue) < e.queueLimit:
+		e.queue = append(e.queue, t)
+		e.mutex.Unlock()
+	case e.policy == DiscardOldest && len(e.queue) > 0:
+		oldest := e.queue[0]
+		e.queue = append(e.queue[1:], t)
+		e.rejected++
+		e.mutex.Unlock()
+		oldest.promise.Reject(ExecutorQueueFullError)
+	case e.policy == CallerRuns:
+		e.mutex.Unlock()
+		e.run(t)
+	default:
+		e.rejected++
+		e.mutex.Unlock()
+		t.promise.Reject(ExecutorQueueFullError)
+	}
+	return t.promise
+}
+
+// work runs t, then the queued tasks, until the queue is empty.
+func (e *Executor) work(t *queuedTask) {
+	for t != nil {
+		e.run(t)
+		e.mutex.Lock()
+		t = nil
+		if len(e.queue) > 0 {
+			t = e.queue[0]
+			e.queue[0] = nil
+			e.queue = e.queue[1:]
+		} else {
+			e.running--
+			e.idle.Broadcast()
+		}
+		e.mutex.Unlock()
+	}
+}
+
+func (e *Executor) run(t *queuedTask) {
+	defer func() {
+		e.mutex.Lock()
+		e.completed++
+		e.mutex.Unlock()
+	}()
+	defer t.promise.handlePanic()
+	result, err := t.task()
+	if err != nil {
+		t.promise.Reject(err)
+	} else {
+		t.promise.Resolve(result)
+	}
+}
+
+// Stats returns the number of running and queued tasks, and how many were
+// completed or rejected so far.
+func (e *Executor) Stats() ExecutorStats {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return ExecutorStats{
+		Running:   e.running,
+		Queued:    len(e.queue),
+		Completed: e.completed,
+		Rejected:  e.rejected,
+	}
+}
+
+// Shutdown rejects the queued tasks and the ones given to Go from now on
+// with ExecutorShutdownError, and waits for the running tasks.
+func (e *Executor) Shutdown() {
+	e.mutex.Lock()
+	e.shutdown = true
+	queue := e.queue
+	e.queue = make([]*queuedTask, 0)
+	e.rejected += uint64(len(queue))
+	e.mutex.Unlock()
+	for _, t := range queue {
+		t.promise.Reject(ExecutorShutdownError)
+	}
+	e.mutex.Lock()
+	for e.running > 0 {
+		e.idle.Wait()
+	}
+	e.mutex.Unlock()
+}
+
+// AllLimit runs tasks with at most n of them in flight, and is resolved
+// like All with their results in the same order.
+func AllLimit(n int, tasks ...Task) *Promise {
+	e := NewExecutor(n, -1, RejectNew)
+	promises := make([]*Promise, len(tasks))
+	for i, task := range tasks {
+		promises[i] = e.Go(task)
+	}
+	return All(promises...)
+}
+
+// Map applies fn to items with at most n calls in flight, and is resolved
+// with the results in the order of items, or rejected with the first error.
+func Map(n int, items []interface{}, fn HandleFunc) *Promise {
+	tasks := make([]Task, len(items))
+	for i, item := range items {
+		item := item
+		tasks[i] = func() (interface{}, error) {
+			return fn(item)
+		}
+	}
+	return AllLimit(n, tasks...)
+}
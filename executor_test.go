@@ -0,0 +1,75 @@
+package stream
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestExecutor(t *testing.T) {
+	release := make(chan struct{})
+	blocked := func() (interface{}, error) {
+		<-release
+		return "done", nil
+	}
+	e := NewExecutor(2, 1, RejectNew)
+	running := []*Promise{e.Go(blocked), e.Go(blocked)}
+	queued := e.Go(blocked)
+	if _, err := e.Go(blocked).Await(); !errors.Is(err, ExecutorQueueFullError) {
+		t.Fatalf("expected the queue to be full, got %v", err)
+	}
+	if stats := e.Stats(); stats.Running != 2 || stats.Queued != 1 || stats.Rejected != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	close(release)
+	for _, p := range append(running, queued) {
+		if v, err := p.Await(); err != nil || v != "done" {
+			t.Fatalf("expected done, got %v, %v", v, err)
+		}
+	}
+	if _, err := e.Go(func() (interface{}, error) { panic("boom") }).Await(); !errors.Is(err, RecoveredPanicError) {
+		t.Fatalf("expected a recovered panic, got %v", err)
+	}
+	e.Shutdown()
+	if stats := e.Stats(); stats.Running != 0 || stats.Completed != 4 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if _, err := e.Go(blocked).Await(); !errors.Is(err, ExecutorShutdownError) {
+		t.Fatalf("expected the executor to be shut down, got %v", err)
+	}
+}
+
+func TestMap(t *testing.T) {
+	var (
+		mutex    sync.Mutex
+		inFlight int
+		peak     int
+	)
+	square := func(v interface{}) (interface{}, error) {
+		mutex.Lock()
+		inFlight++
+		if inFlight > peak {
+			peak = inFlight
+		}
+		mutex.Unlock()
+		defer func() {
+			mutex.Lock()
+			inFlight--
+			mutex.Unlock()
+		}()
+		return v.(int) * v.(int), nil
+	}
+	items := make([]interface{}, 50)
+	expected := make([]interface{}, 50)
+	for i := range items {
+		items[i], expected[i] = i, i*i
+	}
+	v, err := Map(3, items, square).Await()
+	if err != nil || !reflect.DeepEqual(v, expected) {
+		t.Fatalf("expected the squares in order, got %v, %v", v, err)
+	}
+	if peak > 3 {
+		t.Fatalf("expected at most 3 calls in flight, got %d", peak)
+	}
+}
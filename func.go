@@ -29,3 +29,32 @@ func FuncName(f interface{}) (string, string, error) {
 	name := strings.TrimSuffix(strings.TrimPrefix(nameEnd, "."), "-fm")
 	return pkg, name, nil
 }
+
+// HandlerName returns a stable name for anything that can sit in a Streams
+// chain: the Name of a *Handler or *SliceHandler when set, otherwise the
+// package qualified name of the underlying func.
+func HandlerName(fn interface{}) string {
+	switch h := fn.(type) {
+	case nil:
+		return ""
+	case string:
+		return h
+	case *Handler:
+		if h.Name != "" {
+			return h.Name
+		}
+		return HandlerName(h.Apply)
+	case *SliceHandler:
+		if h.Name != "" {
+			return h.Name
+		}
+		return HandlerName(h.Apply)
+	case SliceHandler:
+		return HandlerName(&h)
+	}
+	pkg, name, err := FuncName(fn)
+	if err != nil {
+		return fmt.Sprintf("%T", fn)
+	}
+	return fmt.Sprintf("%s.%s", pkg, name)
+}
@@ -31,7 +31,19 @@ type (
 
 	ErrorHandleFunc func(err error) error
 
+	// Middleware wraps a HandleFunc with behaviour shared by many handlers.
+	Middleware func(HandleFunc) HandleFunc
+
 	Max func(receiver interface{}, apply CompareFunc)
 
 	Min func(receiver interface{}, apply CompareFunc)
+
+	// StatefulFunc defines a func that should be passed to the MapWithState operator.
+	// It receives the current state of the item's key, nil for a new key, and
+	// returns the new state and the value to emit.
+	StatefulFunc func(state interface{}, item interface{}) (interface{}, interface{}, error)
+
+	// ProcessFunc defines a func that should be passed to the ProcessWithState operator.
+	// It may emit any number of values and returns the new state of the item's key.
+	ProcessFunc func(state interface{}, item interface{}, emit ConsumeFunc) (interface{}, error)
 )
@@ -0,0 +1,325 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// GraphError lists everything that is wrong with a Graph.
+type GraphError struct {
+	Problems []string
+}
+
+func (e *GraphError) Error() string {
+	return fmt.Sprintf("invalid graph: %s", strings.Join(e.Problems, "; "))
+}
+
+type graphNode struct {
+	name       string
+	handles    []interface{}
+	buffer     int
+	middleware []Middleware
+	errors     string
+}
+
+type graphEdge struct {
+	to    string
+	route Route
+}
+
+// Graph declares a network of Streams: nodes running handler chains and the
+// edges piping one node's results into another. Build validates the graph
+// and starts it; mistakes made while declaring it are reported there too.
+type Graph struct {
+	nodes    map[string]*graphNode
+	order    []string
+	edges    map[string][]graphEdge
+	problems []string
+}
+
+func NewGraph() *Graph {
+	return &Graph{
+		nodes: make(map[string]*graphNode),
+		edges: make(map[string][]graphEdge),
+	}
+}
+
+// AddNode declares a node running handler, which may be anything a Streams
+// chain accepts, or a []interface{} of several of them.
+func (g *Graph) AddNode(name string, handler interface{}) *Graph {
+	if _, ok := g.nodes[name]; ok {
+		g.problems = append(g.problems, fmt.Sprintf("duplicate node %q", name))
+		return g
+	}
+	handles, ok := handler.([]interface{})
+	if !ok {
+		handles = []interface{}{handler}
+	}
+	g.nodes[name] = &graphNode{name: name, handles: handles}
+	g.order = append(g.order, name)
+	return g
+}
+
+// SetBuffer sets the size of a node's input buffer, unbuffered by default.
+func (g *Graph) SetBuffer(name string, n int) *Graph {
+	node, ok := g.nodes[name]
+	if !ok {
+		g.problems = append(g.problems, fmt.Sprintf("buffer set on unknown node %q", name))
+		return g
+	}
+	node.buffer = n
+	return g
+}
+
+// Use adds middleware applied to every handler of a node.
+func (g *Graph) Use(name string, mws ...Middleware) *Graph {
+	node, ok := g.nodes[name]
+	if !ok {
+		g.problems = append(g.problems, fmt.Sprintf("middleware used on unknown node %q", name))
+		return g
+	}
+	node.middleware = append(node.middleware, mws...)
+	return g
+}
+
+// Connect pipes the results of from into to.
+func (g *Graph) Connect(from, to string) *Graph {
+	return g.ConnectRoute(from, to, Route{})
+}
+
+// ConnectRoute pipes the results of from into to through the Filter,
+// Transform and Errors of route. route.Target is set by Build.
+func (g *Graph) ConnectRoute(from, to string, route Route) *Graph {
+	for _, e := range g.edges[from] {
+		if e.to == to {
+			g.problems = append(g.problems, fmt.Sprintf("duplicate edge %s -> %s", from, to))
+			return g
+		}
+	}
+	g.edges[from] = append(g.edges[from], graphEdge{to: to, route: route})
+	return g
+}
+
+// ConnectErrors sends the failures of from to the input of to.
+func (g *Graph) ConnectErrors(from, to string) *Graph {
+	node, ok := g.nodes[from]
+	if !ok {
+		g.problems = append(g.problems, fmt.Sprintf("error route from unknown node %q", from))
+		return g
+	}
+	node.errors = to
+	return g
+}
+
+// targets returns the nodes fed by name, through results or errors.
+func (g *Graph) targets(name string) []string {
+	targets := make([]string, 0, len(g.edges[name])+1)
+	for _, e := range g.edges[name] {
+		targets = append(targets, e.to)
+	}
+	if node, ok := g.nodes[name]; ok && node.errors != "" {
+		targets = append(targets, node.errors)
+	}
+	return targets
+}
+
+const (
+	unvisited = iota
+	visiting
+	visited
+)
+
+type graphFrame struct {
+	name string
+	exit bool
+}
+
+// validate checks the graph and returns its nodes in topological order.
+func (g *Graph) validate() ([]string, error) {
+	problems := append([]string{}, g.problems...)
+	if len(g.nodes) == 0 {
+		problems = append(problems, "graph has no nodes")
+	}
+	inDegree := make(map[string]int)
+	for _, from := range g.order {
+		for _, to := range g.targets(from) {
+			if _, ok := g.nodes[to]; !ok {
+				problems = append(problems, fmt.Sprintf("edge %s -> %s targets an unknown node", from, to))
+				continue
+			}
+			inDegree[to]++
+		}
+	}
+	unknown := make([]string, 0)
+	for from := range g.edges {
+		if _, ok := g.nodes[from]; !ok {
+			unknown = append(unknown, from)
+		}
+	}
+	sort.Strings(unknown)
+	for _, from := range unknown {
+		problems = append(problems, fmt.Sprintf("edge from unknown node %q", from))
+	}
+	sinks := 0
+	for _, name := range g.order {
+		out := len(g.targets(name))
+		switch {
+		case len(g.nodes) == 1:
+			sinks++
+		case out == 0 && inDegree[name] == 0:
+			problems = append(problems, fmt.Sprintf("node %q is not connected", name))
+		case out == 0:
+			sinks++
+		}
+	}
+	if len(g.nodes) > 0 && sinks == 0 {
+		problems = append(problems, "graph has no sink")
+	}
+
+	state := make(map[string]int)
+	post := make([]string, 0, len(g.nodes))
+	stack := NewStack[graphFrame]()
+	for _, root := range g.order {
+		if state[root] != unvisited {
+			continue
+		}
+		stack.Push(graphFrame{name: root})
+		for {
+			f, ok := stack.Pop()
+			if !ok {
+				break
+			}
+			if f.exit {
+				state[f.name] = visited
+				post = append(post, f.name)
+				continue
+			}
+			if state[f.name] != unvisited {
+				continue
+			}
+			state[f.name] = visiting
+			stack.Push(graphFrame{name: f.name, exit: true})
+			targets := g.targets(f.name)
+			for i := len(targets) - 1; i >= 0; i-- {
+				next := targets[i]
+				if _, ok := g.nodes[next]; !ok {
+					continue
+				}
+				switch state[next] {
+				case unvisited:
+					stack.Push(graphFrame{name: next})
+				case visiting:
+					problems = append(problems, fmt.Sprintf("edge %s -> %s closes a cycle", f.name, next))
+				}
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, &GraphError{Problems: problems}
+	}
+	order := make([]string, len(post))
+	for i, name := range post {
+		order[len(post)-1-i] = name
+	}
+	return order, nil
+}
+
+// Validate reports every problem Build would refuse to start with.
+func (g *Graph) Validate() error {
+	_, err := g.validate()
+	return err
+}
+
+// Build validates the graph and starts one Streams per node, downstream
+// nodes before the nodes feeding them.
+func (g *Graph) Build(ctx context.Context) (*Network, error) {
+	order, err := g.validate()
+	if err != nil {
+		return nil, err
+	}
+	n := &Network{
+		nodes: make(map[string]Streams),
+		order: order,
+	}
+	for i := len(order) - 1; i >= 0; i-- {
+		node := g.nodes[order[i]]
+		s := With(node.name, ctx, node.buffer, node.handles...)
+		if len(node.middleware) > 0 {
+			s.Use(node.middleware...)
+		}
+		routes := make([]*Route, 0, len(g.edges[node.name]))
+		for _, e := range g.edges[node.name] {
+			route := e.route
+			route.Target = n.nodes[e.to]
+			routes = append(routes, &route)
+		}
+		if node.errors != "" {
+			routes = append(routes, &Route{
+				Name:   fmt.Sprintf("errors-%s", node.errors),
+				Errors: n.nodes[node.errors].Input(),
+			})
+		}
+		s.PipeRoute(routes...)
+		n.nodes[node.name] = s
+	}
+	return n, nil
+}
+
+// Network is a running Graph.
+type Network struct {
+	nodes map[string]Streams
+	order []string
+}
+
+// Node returns the Streams running the named node.
+func (n *Network) Node(name string) Streams {
+	return n.nodes[name]
+}
+
+// Input returns the input of the named node, nil for an unknown node.
+func (n *Network) Input(name string) Stream {
+	if s, ok := n.nodes[name]; ok {
+		return s.Input()
+	}
+	return nil
+}
+
+// Order returns the node names in topological order, sources first.
+func (n *Network) Order() []string {
+	return append([]string{}, n.order...)
+}
+
+// Names returns the node names sorted alphabetically.
+func (n *Network) Names() []string {
+	names := make([]string, 0, len(n.nodes))
+	for name := range n.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Shutdown shuts every node down, sources first, so that each node has
+// drained into the nodes it feeds before they are shut down in turn. It
+// returns the error of the first node that did not finish before ctx ended.
+func (n *Network) Shutdown(ctx context.Context) error {
+	for _, name := range n.order {
+		if err := n.nodes[name].Shutdown(ctx); err != nil {
+			for _, rest := range n.order {
+				n.nodes[rest].Cancel()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes every node, sources first.
+func (n *Network) Close() {
+	for _, name := range n.order {
+		n.nodes[name].Close()
+	}
+}
@@ -0,0 +1,110 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGraph_Validate(t *testing.T) {
+	pass := HandleFunc(func(v interface{}) (interface{}, error) {
+		return v, nil
+	})
+	err := NewGraph().
+		AddNode("a", pass).
+		AddNode("b", pass).
+		AddNode("c", pass).
+		AddNode("lonely", pass).
+		Connect("a", "b").
+		Connect("b", "c").
+		Connect("c", "a").
+		Connect("c", "missing").
+		Validate()
+	if err == nil {
+		t.Fatal("expected the graph to be rejected")
+	}
+	for _, problem := range []string{"c -> a closes a cycle", `"lonely" is not connected`, "no sink", "c -> missing targets an unknown node"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %q in %v", problem, err)
+		}
+	}
+}
+
+func TestGraph_Build(t *testing.T) {
+	out := New(10)
+	inc := func(v interface{}) (interface{}, error) {
+		return v.(int) + 1, nil
+	}
+	sink := func(v interface{}) (interface{}, error) {
+		out <- v
+		return v, nil
+	}
+	n, err := NewGraph().
+		AddNode("sink", sink).
+		AddNode("inc", inc).
+		AddNode("source", inc).
+		Connect("source", "inc").
+		Connect("inc", "sink").
+		Build(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	if order := n.Order(); !reflect.DeepEqual(order, []string{"source", "inc", "sink"}) {
+		t.Fatalf("unexpected order %v", order)
+	}
+	n.Input("source") <- 1
+	if v := <-out; v != 3 {
+		t.Fatalf("expected 3, got %v", v)
+	}
+}
+
+func TestNetwork_Shutdown(t *testing.T) {
+	var (
+		mutex sync.Mutex
+		got   []interface{}
+	)
+	slow := func(v interface{}) (interface{}, error) {
+		time.Sleep(5 * time.Millisecond)
+		return v, nil
+	}
+	collect := func(v interface{}) (interface{}, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		got = append(got, v)
+		return v, nil
+	}
+	n, err := NewGraph().
+		AddNode("in", slow).SetBuffer("in", 10).
+		AddNode("out", []interface{}{slow, collect}).
+		Connect("in", "out").
+		Build(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		n.Input("in") <- i
+	}
+	if err := n.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !reflect.DeepEqual(got, []interface{}{0, 1, 2, 3, 4}) {
+		t.Fatalf("expected every buffered item to be drained, got %v", got)
+	}
+
+	s := With("late", context.Background(), 10, slow)
+	for i := 0; i < 5; i++ {
+		s.Input() <- i
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to pass, got %v", err)
+	}
+}
@@ -10,11 +10,12 @@ type handler interface {
 }
 
 type Handler struct {
-	Apply  HandleFunc
-	Name   string
-	Before HandleFunc
-	After  HandleFunc
-	Pipes  []Stream
+	Apply      HandleFunc
+	Name       string
+	Before     HandleFunc
+	After      HandleFunc
+	Pipes      []Stream
+	Middleware []Middleware
 }
 
 func (h *Handler) Eval(v interface{}) (interface{}, error) {
@@ -22,11 +23,12 @@ func (h *Handler) Eval(v interface{}) (interface{}, error) {
 }
 
 type SliceHandler struct {
-	Apply  HandleFunc
-	Name   string
-	Before HandleFunc
-	After  HandleFunc
-	Pipes  []Stream
+	Apply      HandleFunc
+	Name       string
+	Before     HandleFunc
+	After      HandleFunc
+	Pipes      []Stream
+	Middleware []Middleware
 }
 
 var (
@@ -37,9 +39,9 @@ func (h *SliceHandler) Eval(v interface{}) (interface{}, error) {
 	t := reflect.TypeOf(v)
 	if k := t.Kind(); k == reflect.Slice || k == reflect.Array {
 		m := reflect.ValueOf(v)
-		result := make([]interface{}, m.Len())
+		result := make([]interface{}, 0, m.Len())
 		for i := 0; i < m.Len(); i++ {
-			ele := m.Index(i)
+			ele := m.Index(i).Interface()
 			r, err := h.Apply(ele)
 			if err != nil {
 				return nil, err
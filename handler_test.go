@@ -0,0 +1,25 @@
+package stream
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSliceHandler_Eval(t *testing.T) {
+	h := &SliceHandler{Apply: func(v interface{}) (interface{}, error) {
+		return v.(int) * 2, nil
+	}}
+	for _, input := range []interface{}{[]int{1, 2, 3}, [3]int{1, 2, 3}} {
+		result, err := h.Eval(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the elements are passed as their values and the result holds only the results
+		if !reflect.DeepEqual(result, []interface{}{2, 4, 6}) {
+			t.Fatalf("expected [2 4 6] for %v, got %v", input, result)
+		}
+	}
+	if _, err := h.Eval(1); err != InputNotASliceError {
+		t.Fatalf("expected InputNotASliceError, got %v", err)
+	}
+}
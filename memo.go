@@ -0,0 +1,100 @@
+package stream
+
+import (
+	"sync"
+	"time"
+)
+
+type memoEntry struct {
+	promise *Promise
+	expires time.Time
+}
+
+// Memo shares one promise per key between concurrent callers. A task runs
+// at most once per key while it is in flight; its result is then cached
+// for the TTL given to NewMemo, or forgotten right away for a zero TTL.
+// Rejections are never cached.
+type Memo struct {
+	ttl     time.Duration
+	entries map[string]*memoEntry
+	mutex   sync.Mutex
+}
+
+func NewMemo(ttl time.Duration) *Memo {
+	return &Memo{
+		ttl:     ttl,
+		entries: make(map[string]*memoEntry),
+	}
+}
+
+// Do returns the promise of the task in flight or cached for key, or runs
+// task and returns its promise.
+func (m *Memo) Do(key string, task Task) *Promise {
+	m.mutex.Lock()
+	if e, ok := m.entries[key]; ok && (e.expires.IsZero() || time.Now().Before(e.expires)) {
+		m.mutex.Unlock()
+		return e.promise
+	}
+	e := &memoEntry{}
+	e.promise = NewPromise(func(resolve func(interface{}), reject func(error)) {
+		result, err := safeTask(task)
+		m.settled(key, e, err)
+		if err != nil {
+			reject(err)
+		} else {
+			resolve(result)
+		}
+	})
+	m.entries[key] = e
+	m.mutex.Unlock()
+	return e.promise
+}
+
+// settled caches or drops the entry of key once its task has finished,
+// before its promise settles.
+func (m *Memo) settled(key string, e *memoEntry, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.entries[key] != e {
+		// forgotten meanwhile
+		return
+	}
+	if err != nil || m.ttl <= 0 {
+		delete(m.entries, key)
+		return
+	}
+	e.expires = time.Now().Add(m.ttl)
+}
+
+// Forget drops what is cached for key, so that the next Do runs the task
+// again. Callers already holding the promise keep it.
+func (m *Memo) Forget(key string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.entries, key)
+}
+
+// Clear forgets every key.
+func (m *Memo) Clear() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.entries = make(map[string]*memoEntry)
+}
+
+// Len returns the number of keys in flight or cached, expired ones included
+// until they are asked for again.
+func (m *Memo) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.entries)
+}
+
+// safeTask runs task, turning a panic into a *PanicError.
+func safeTask(task Task) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, NewPanicError(r, nil)
+		}
+	}()
+	return task()
+}
@@ -0,0 +1,62 @@
+package stream
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemo(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	lookup := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "value", nil
+	}
+	m := NewMemo(time.Hour)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := m.Do("key", lookup).Await(); err != nil || v != "value" {
+				t.Errorf("expected value, got %v, %v", v, err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("expected concurrent callers to share one lookup, got %d", calls)
+	}
+	m.Do("key", lookup).Await()
+	if calls != 1 {
+		t.Fatalf("expected the result to be cached, got %d lookups", calls)
+	}
+	m.Forget("key")
+	m.Do("key", lookup).Await()
+	if calls != 2 {
+		t.Fatalf("expected Forget to drop the result, got %d lookups", calls)
+	}
+
+	failed := errors.New("failed")
+	failing := func() (interface{}, error) { return nil, failed }
+	if _, err := m.Do("bad", failing).Await(); err != failed {
+		t.Fatalf("expected failed, got %v", err)
+	}
+	if m.Len() != 1 {
+		t.Fatalf("expected rejections not to be cached, got %d keys", m.Len())
+	}
+
+	short := NewMemo(time.Millisecond)
+	short.Do("key", lookup).Await()
+	time.Sleep(5 * time.Millisecond)
+	short.Do("key", lookup).Await()
+	if calls != 4 {
+		t.Fatalf("expected the result to expire, got %d lookups", calls)
+	}
+}
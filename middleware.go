@@ -0,0 +1,108 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	RecoveredPanicError = errors.New("handler panicked")
+	InvalidItemError    = errors.New("invalid item")
+)
+
+// Chain composes mws into one Middleware. The first one is the outermost:
+// it sees the item first and the result last.
+func Chain(mws ...Middleware) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		for i := len(mws) - 1; i >= 0; i-- {
+			if mws[i] != nil {
+				next = mws[i](next)
+			}
+		}
+		return next
+	}
+}
+
+// Use adds middleware applied to every handler of every Streams resolving
+// from DefaultRegistry.
+func Use(mws ...Middleware) {
+	DefaultRegistry.Use(mws...)
+}
+
+// Logging logs every item with its result or error. logf defaults to
+// log.Printf.
+func Logging(name string, logf func(format string, v ...interface{})) Middleware {
+	if logf == nil {
+		logf = log.Printf
+	}
+	return func(next HandleFunc) HandleFunc {
+		return func(v interface{}) (interface{}, error) {
+			r, err := next(v)
+			if err != nil {
+				logf("%s: %v failed: %v", name, v, err)
+			} else {
+				logf("%s: %v -> %v", name, v, r)
+			}
+			return r, err
+		}
+	}
+}
+
+// Recover turns a panic of the handler into a *PanicError, which matches
+// RecoveredPanicError.
+func Recover() Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(v interface{}) (r interface{}, err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					r, err = nil, NewPanicError(p, v)
+				}
+			}()
+			return next(v)
+		}
+	}
+}
+
+// Timing reports how long the handler took for every item.
+func Timing(observe func(d time.Duration, err error)) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(v interface{}) (interface{}, error) {
+			start := time.Now()
+			r, err := next(v)
+			observe(time.Since(start), err)
+			return r, err
+		}
+	}
+}
+
+// Retry calls the handler up to attempts times while it fails, waiting
+// backoff before the first retry and twice as long before each next one.
+func Retry(attempts int, backoff time.Duration) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(v interface{}) (interface{}, error) {
+			delay := backoff
+			for i := 1; ; i++ {
+				r, err := next(v)
+				if err == nil || i >= attempts {
+					return r, err
+				}
+				time.Sleep(delay)
+				delay *= 2
+			}
+		}
+	}
+}
+
+// Validate rejects the items check fails on, without calling the handler.
+func Validate(check func(interface{}) error) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(v interface{}) (interface{}, error) {
+			if err := check(v); err != nil {
+				return nil, fmt.Errorf("%w: %v", InvalidItemError, err)
+			}
+			return next(v)
+		}
+	}
+}
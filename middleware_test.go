@@ -0,0 +1,95 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func trace(calls *[]string, name string) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(v interface{}) (interface{}, error) {
+			*calls = append(*calls, name)
+			return next(v)
+		}
+	}
+}
+
+func TestMiddleware_Order(t *testing.T) {
+	var calls []string
+	r := NewRegistry()
+	r.Use(trace(&calls, "global"))
+	s := Once("middleware", WithRegistry(context.Background(), r),
+		&Handler{
+			Name:       "inc",
+			Apply:      func(v interface{}) (interface{}, error) { return v.(int) + 1, nil },
+			Middleware: []Middleware{trace(&calls, "handler")},
+		},
+		HandleFunc(func(v interface{}) (interface{}, error) { return v.(int) * 10, nil }),
+	)
+	s.Use(trace(&calls, "streams"))
+	s.Resolve(1)
+	if v, err := s.Await(); err != nil || v != 20 {
+		t.Fatalf("expected 20, got %v, %v", v, err)
+	}
+	expected := []string{"global", "streams", "handler", "global", "streams"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("expected %v, got %v", expected, calls)
+	}
+}
+
+func TestMiddleware_BuiltIns(t *testing.T) {
+	attempts := 0
+	flaky := func(v interface{}) (interface{}, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, errors.New("not yet")
+		}
+		if v.(int) < 0 {
+			panic("negative")
+		}
+		return v, nil
+	}
+	var logs []string
+	var timed int
+	s := Once("builtins", context.Background(), &Handler{
+		Name:  "flaky",
+		Apply: flaky,
+		Middleware: []Middleware{
+			Logging("flaky", func(format string, v ...interface{}) {
+				logs = append(logs, fmt.Sprintf(format, v...))
+			}),
+			Recover(),
+			Timing(func(d time.Duration, err error) { timed++ }),
+			Validate(func(v interface{}) error {
+				if _, ok := v.(int); !ok {
+					return errors.New("not an int")
+				}
+				return nil
+			}),
+			Retry(3, time.Millisecond),
+		},
+	})
+
+	s.Resolve(7)
+	if v, err := s.Await(); err != nil || v != 7 || attempts != 3 {
+		t.Fatalf("expected 7 after 3 attempts, got %v, %v after %d", v, err, attempts)
+	}
+	s.Resolve("x")
+	if _, err := s.Await(); !errors.Is(err, InvalidItemError) {
+		t.Fatalf("expected an invalid item error, got %v", err)
+	}
+	s.Resolve(-1)
+	if _, err := s.Await(); !errors.Is(err, RecoveredPanicError) {
+		t.Fatalf("expected a recovered panic, got %v", err)
+	}
+	if len(logs) != 3 || logs[0] != "flaky: 7 -> 7" {
+		t.Fatalf("unexpected logs %v", logs)
+	}
+	if timed != 2 {
+		t.Fatalf("expected 2 timings, got %d", timed)
+	}
+}
@@ -1,9 +1,12 @@
 package stream
 
 import (
-	"runtime"
-	"runtime/debug"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -36,13 +39,12 @@ type Promise struct {
 	// an error or panic occurred.
 	executor func(resolve func(interface{}), reject func(error))
 
-	// Appends fulfillment to the promise,
-	// and returns a new promise.
-	then []func(data interface{}) interface{}
+	// Set once the promise follows another promise it was resolved with;
+	// later calls to resolve or reject are ignored.
+	locked bool
 
-	// Appends a rejection handler to the promise,
-	// and returns a new promise.
-	catch []func(err error) error
+	// Callbacks run once the promise is settled, in the order they were added.
+	callbacks []func()
 
 	// Stores the result passed to resolve()
 	result interface{}
@@ -53,24 +55,31 @@ type Promise struct {
 	// Mutex protects against data race conditions.
 	mutex *sync.Mutex
 
-	// WaitGroup allows to block until all callbacks are executed.
-	wg *sync.WaitGroup
+	// Closed once the promise is settled.
+	done chan struct{}
+
+	// Cancels the context of a promise made with NewPromiseCtx.
+	cancelFunc context.CancelFunc
+
+	// Set for a promise made with Lazy, whose executor runs once the
+	// promise is first awaited or chained.
+	lazy    bool
+	started sync.Once
 }
 
+// ChainingCycleError rejects a promise resolved with itself.
+var ChainingCycleError = errors.New("promise resolved with itself")
+
 // NewPromise instantiates and returns a pointer to the Promise.
 func NewPromise(executor func(resolve func(interface{}), reject func(error))) *Promise {
-	var wg = &sync.WaitGroup{}
-	wg.Add(1)
-
 	var promise = &Promise{
-		state:    pending,
-		executor: executor,
-		then:     make([]func(interface{}) interface{}, 0),
-		catch:    make([]func(error) error, 0),
-		result:   nil,
-		err:      nil,
-		mutex:    &sync.Mutex{},
-		wg:       wg,
+		state:     pending,
+		executor:  executor,
+		callbacks: make([]func(), 0),
+		result:    nil,
+		err:       nil,
+		mutex:     &sync.Mutex{},
+		done:      make(chan struct{}),
 	}
 	if promise.executor != nil {
 		go func() {
@@ -82,157 +91,264 @@ func NewPromise(executor func(resolve func(interface{}), reject func(error))) *P
 	return promise
 }
 
+// Lazy is NewPromise deferring the executor until the promise is first
+// awaited, or handlers are added with Then, Catch or Finally, or it is
+// given to a combinator.
+func Lazy(executor func(resolve func(interface{}), reject func(error))) *Promise {
+	promise := NewPromise(nil)
+	promise.executor = executor
+	promise.lazy = true
+	return promise
+}
+
+// start runs the executor of a lazy promise the first time it is called.
+func (promise *Promise) start() {
+	promise.started.Do(func() {
+		if promise.lazy && promise.executor != nil {
+			go func() {
+				defer promise.handlePanic()
+				promise.executor(promise.Resolve, promise.Reject)
+			}()
+		}
+	})
+}
+
+// NewPromiseCtx is NewPromise with a context. The promise is rejected with
+// ctx.Err() when ctx ends before it settles. The executor is given a
+// context that is cancelled as soon as the promise is settled or cancelled,
+// so that it can stop its work.
+func NewPromiseCtx(ctx context.Context, executor func(ctx context.Context, resolve func(interface{}), reject func(error))) *Promise {
+	ctx, cancel := context.WithCancel(ctx)
+	promise := NewPromise(nil)
+	promise.cancelFunc = cancel
+	go func() {
+		<-ctx.Done()
+		promise.settle(nil, ctx.Err())
+	}()
+	if executor != nil {
+		go func() {
+			defer promise.handlePanic()
+			executor(ctx, promise.Resolve, promise.Reject)
+		}()
+	}
+	return promise
+}
+
+// Cancel rejects the promise with context.Canceled if it is still pending,
+// and signals the executor of a promise made with NewPromiseCtx.
+func (promise *Promise) Cancel() {
+	promise.settle(nil, context.Canceled)
+}
+
+// WithTimeout rejects the promise with context.DeadlineExceeded if it has
+// not settled within d, as Cancel does, and returns it.
+func (promise *Promise) WithTimeout(d time.Duration) *Promise {
+	timer := time.AfterFunc(d, func() {
+		promise.settle(nil, context.DeadlineExceeded)
+	})
+	promise.subscribe(func(interface{}, error) {
+		timer.Stop()
+	})
+	return promise
+}
+
+// Done returns a channel closed once the promise is settled.
+func (promise *Promise) Done() <-chan struct{} {
+	promise.start()
+	return promise.done
+}
+
+// Resolve fulfills the promise with resolution. A *Promise resolution is
+// followed: the promise settles the same way once it does. An error
+// resolution rejects the promise.
 func (promise *Promise) Resolve(resolution interface{}) {
 	promise.mutex.Lock()
-
-	if promise.state != pending {
+	if promise.state != pending || promise.locked {
 		promise.mutex.Unlock()
 		return
 	}
-
 	switch result := resolution.(type) {
 	case *Promise:
-		res, err := result.Await()
-		if err != nil {
-			//promise.mutex.Unlock()
-			promise.err = err
+		if result == promise {
+			promise.mutex.Unlock()
+			promise.Reject(ChainingCycleError)
+			return
 		}
-		promise.result = res
+		promise.locked = true
+		promise.mutex.Unlock()
+		result.subscribe(func(res interface{}, err error) {
+			promise.settle(res, err)
+		})
+		return
 	case error:
-		promise.err = result.(error)
-	default:
-		promise.result = result
-	}
-
-	promise.wg.Done()
-
-	for _, fn := range promise.then {
-		if promise.err == nil {
-			switch result := fn(promise.result).(type) {
-			case *Promise:
-				res, err := result.Await()
-				if err != nil {
-					promise.err = err
-				}
-				promise.result = res
-			case error:
-				promise.err = result.(error)
-			default:
-				promise.result = result
-			}
-		}
-		promise.wg.Done()
-	}
-
-	if promise.err != nil {
-		promise.reject()
-	} else {
-		for range promise.catch {
-			promise.wg.Done()
-		}
-		promise.state = fulfilled
+		promise.mutex.Unlock()
+		promise.Reject(result)
+		return
 	}
-
 	promise.mutex.Unlock()
+	promise.settle(resolution, nil)
 }
 
+// Reject rejects the promise with err.
 func (promise *Promise) Reject(err error) {
 	promise.mutex.Lock()
-	defer promise.mutex.Unlock()
+	locked := promise.locked
+	promise.mutex.Unlock()
+	if !locked {
+		promise.settle(nil, err)
+	}
+}
 
+// settle records the outcome of the promise and runs its callbacks.
+func (promise *Promise) settle(result interface{}, err error) {
+	promise.mutex.Lock()
 	if promise.state != pending {
+		promise.mutex.Unlock()
 		return
 	}
+	promise.result, promise.err = result, err
+	if err != nil {
+		promise.state = rejected
+	} else {
+		promise.state = fulfilled
+	}
+	callbacks := promise.callbacks
+	promise.callbacks = nil
+	close(promise.done)
+	cancel := promise.cancelFunc
+	promise.mutex.Unlock()
 
-	promise.err = err
-
-	promise.wg.Done()
-
-	promise.reject()
-
-	for range promise.then {
-		promise.wg.Done()
+	if cancel != nil {
+		// signal the executor, which may still be running
+		cancel()
+	}
+	for _, fn := range callbacks {
+		fn()
 	}
 }
 
-func (promise *Promise) reject() {
-	for _, fn := range promise.catch {
-		if promise.err != nil {
-			promise.err = fn(promise.err)
-		}
-		if promise.state == pending {
-			promise.wg.Done()
-		}
+// subscribe calls fn with the outcome of the promise once it is settled,
+// right away when it already is.
+func (promise *Promise) subscribe(fn func(result interface{}, err error)) {
+	callback := func() {
+		fn(promise.result, promise.err)
+	}
+	promise.start()
+	promise.mutex.Lock()
+	if promise.state == pending {
+		promise.callbacks = append(promise.callbacks, callback)
+		promise.mutex.Unlock()
+		return
 	}
-	promise.state = rejected
+	promise.mutex.Unlock()
+	callback()
 }
 
 func (promise *Promise) handlePanic() {
 	var r = recover()
 	if r != nil {
-		err := r.(runtime.Error)
-		debug.PrintStack()
-		promise.Reject(err)
+		promise.Reject(NewPanicError(r, nil))
 	}
 }
 
-// Then appends fulfillment handler to the Promise, and returns a new promise.
-func (promise *Promise) Then(fulfillment func(data interface{}) interface{}) *Promise {
-	promise.mutex.Lock()
-	defer promise.mutex.Unlock()
-
-	switch promise.state {
-	case pending:
-		promise.wg.Add(1)
-		promise.then = append(promise.then, fulfillment)
-	case fulfilled:
-		switch result := fulfillment(promise.result).(type) {
-		case error:
-			promise.err = result.(error)
-			promise.reject()
-		default:
-			promise.result = result
-		}
+// Then appends fulfillment handler to the Promise, and returns a new promise
+// resolved with what the handler returns. The optional rejection handler is
+// called instead when the Promise is rejected, and its return value
+// resolves the new promise too. Without a handler for the outcome, the new
+// promise settles like the Promise. Returned promises are flattened and
+// returned errors reject the new promise.
+func (promise *Promise) Then(fulfillment func(data interface{}) interface{}, rejection ...func(err error) interface{}) *Promise {
+	var onRejected func(err error) interface{}
+	if len(rejection) > 0 {
+		onRejected = rejection[0]
 	}
-
-	return promise
+	return promise.chain(fulfillment, onRejected)
 }
 
-// Catch appends a rejection handler callback to the Promise, and returns a new promise.
+// Catch appends a rejection handler callback to the Promise, and returns a
+// new promise. The new promise is rejected with the error the handler
+// returns, or fulfilled with nil when it returns nil. A fulfilled Promise
+// passes its value on.
 func (promise *Promise) Catch(rejection func(err error) error) *Promise {
-	promise.mutex.Lock()
-	defer promise.mutex.Unlock()
-
-	switch promise.state {
-	case pending:
-		promise.wg.Add(1)
-		promise.catch = append(promise.catch, rejection)
-	case rejected:
-		promise.err = rejection(promise.err)
-	}
+	return promise.chain(nil, func(err error) interface{} {
+		if err = rejection(err); err != nil {
+			return err
+		}
+		return nil
+	})
+}
 
-	return promise
+func (promise *Promise) chain(onFulfilled func(interface{}) interface{}, onRejected func(error) interface{}) *Promise {
+	next := NewPromise(nil)
+	promise.subscribe(func(result interface{}, err error) {
+		defer next.handlePanic()
+		switch {
+		case err == nil && onFulfilled != nil:
+			next.Resolve(onFulfilled(result))
+		case err == nil:
+			next.Resolve(result)
+		case onRejected != nil:
+			next.Resolve(onRejected(err))
+		default:
+			next.Reject(err)
+		}
+	})
+	return next
+}
+
+// Finally calls fn once the Promise is settled, either way, and returns a
+// new promise settled like the Promise. If fn panics, the new promise is
+// rejected with a *PanicError.
+func (promise *Promise) Finally(fn func()) *Promise {
+	return promise.chain(func(data interface{}) interface{} {
+		fn()
+		return data
+	}, func(err error) interface{} {
+		fn()
+		return err
+	})
 }
 
-// Await is a blocking function that waits for all callbacks to be executed.
+// Await is a blocking function that waits for the Promise to be settled.
 // Returns value and error.
 // Call on an already resolved Promise to get its result and error
 func (promise *Promise) Await() (interface{}, error) {
-	promise.wg.Wait()
+	promise.start()
+	<-promise.done
 	return promise.result, promise.err
 }
 
+// AwaitCtx is Await giving up with ctx.Err() when ctx ends first. The
+// promise itself is left as it is.
+func (promise *Promise) AwaitCtx(ctx context.Context) (interface{}, error) {
+	promise.start()
+	select {
+	case <-promise.done:
+		return promise.result, promise.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// cancelPending cancels the promises that have not settled yet.
+func cancelPending(promises []*Promise) {
+	for _, p := range promises {
+		p.Cancel()
+	}
+}
+
 // All waits for all promises to be resolved, or for any to be rejected.
 // If the returned promise resolves, it is resolved with an aggregating array of the values
 // from the resolved promises in the same order as defined in the iterable of multiple promises.
 // If it rejects, it is rejected with the reason from the first promise in the iterable that was rejected.
+// Cancelling it cancels the promises still pending.
 func All(promises ...*Promise) *Promise {
 	psLen := len(promises)
 	if psLen == 0 {
 		return Resolve(make([]interface{}, 0))
 	}
 
-	return NewPromise(func(resolve func(interface{}), reject func(error)) {
+	return NewPromiseCtx(context.Background(), func(ctx context.Context, resolve func(interface{}), reject func(error)) {
 		resolutionsChan := make(chan []interface{}, psLen)
 		errorChan := make(chan error, psLen)
 
@@ -257,6 +373,10 @@ func All(promises ...*Promise) *Promise {
 			case err := <-errorChan:
 				reject(err)
 				return
+
+			case <-ctx.Done():
+				cancelPending(promises)
+				return
 			}
 		}
 		resolve(resolutions)
@@ -266,13 +386,14 @@ func All(promises ...*Promise) *Promise {
 // Race waits until any of the promises is resolved or rejected.
 // If the returned promise resolves, it is resolved with the value of the first promise in the iterable
 // that resolved. If it rejects, it is rejected with the reason from the first promise that was rejected.
+// Cancelling it cancels the promises still pending.
 func Race(promises ...*Promise) *Promise {
 	psLen := len(promises)
 	if psLen == 0 {
 		return Resolve(nil)
 	}
 
-	return NewPromise(func(resolve func(interface{}), reject func(error)) {
+	return NewPromiseCtx(context.Background(), func(ctx context.Context, resolve func(interface{}), reject func(error)) {
 		resolutionsChan := make(chan interface{}, psLen)
 		errorChan := make(chan error, psLen)
 
@@ -292,40 +413,130 @@ func Race(promises ...*Promise) *Promise {
 
 		case err := <-errorChan:
 			reject(err)
+
+		case <-ctx.Done():
+			cancelPending(promises)
 		}
 	})
 }
 
+// Statuses of a SettledResult.
+const (
+	StatusFulfilled = "fulfilled"
+	StatusRejected  = "rejected"
+)
+
+// SettledResult is the outcome of one promise given to AllSettled.
+type SettledResult struct {
+	Status string
+	Value  interface{}
+	Err    error
+}
+
 // AllSettled waits until all promises have settled (each may resolve, or reject).
 // Returns a promise that resolves after all of the given promises have either resolved or rejected,
-// with an array of objects that each describe the outcome of each promise.
+// with a []SettledResult that describes the outcome of each promise, in the same order.
+// Cancelling it cancels the promises still pending.
 func AllSettled(promises ...*Promise) *Promise {
 	psLen := len(promises)
 	if psLen == 0 {
-		return Resolve(make([]interface{}, 0))
+		return Resolve(make([]SettledResult, 0))
 	}
 
-	return NewPromise(func(resolve func(interface{}), reject func(error)) {
-		resolutionsChan := make(chan []interface{}, psLen)
+	return NewPromiseCtx(context.Background(), func(ctx context.Context, resolve func(interface{}), reject func(error)) {
+		type settled struct {
+			index  int
+			result SettledResult
+		}
+		settledChan := make(chan settled, psLen)
 
 		for index, promise := range promises {
 			func(i int) {
-				promise.Then(func(data interface{}) interface{} {
-					resolutionsChan <- []interface{}{i, data}
-					return data
-				}).Catch(func(err error) error {
-					resolutionsChan <- []interface{}{i, err}
-					return err
+				promise.subscribe(func(data interface{}, err error) {
+					if err != nil {
+						settledChan <- settled{i, SettledResult{Status: StatusRejected, Err: err}}
+					} else {
+						settledChan <- settled{i, SettledResult{Status: StatusFulfilled, Value: data}}
+					}
 				})
 			}(index)
 		}
 
-		resolutions := make([]interface{}, psLen)
+		results := make([]SettledResult, psLen)
+		for x := 0; x < psLen; x++ {
+			select {
+			case s := <-settledChan:
+				results[s.index] = s.result
+
+			case <-ctx.Done():
+				cancelPending(promises)
+				return
+			}
+		}
+		resolve(results)
+	})
+}
+
+// AggregateError rejects the promise returned by Any when every promise was
+// rejected, with their reasons in the order of the promises.
+type AggregateError struct {
+	Errors []error
+}
+
+func (e *AggregateError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("all promises were rejected: [%s]", strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the reasons of the rejections.
+func (e *AggregateError) Unwrap() []error {
+	return e.Errors
+}
+
+// Any waits until any of the promises is fulfilled, and is resolved with its
+// value. If every promise is rejected, it is rejected with an
+// *AggregateError. Cancelling it cancels the promises still pending.
+func Any(promises ...*Promise) *Promise {
+	psLen := len(promises)
+	if psLen == 0 {
+		return Reject(&AggregateError{Errors: make([]error, 0)})
+	}
+
+	return NewPromiseCtx(context.Background(), func(ctx context.Context, resolve func(interface{}), reject func(error)) {
+		resolutionsChan := make(chan interface{}, psLen)
+		errorChan := make(chan []interface{}, psLen)
+
+		for index, promise := range promises {
+			func(i int) {
+				promise.subscribe(func(data interface{}, err error) {
+					if err != nil {
+						errorChan <- []interface{}{i, err}
+					} else {
+						resolutionsChan <- data
+					}
+				})
+			}(index)
+		}
+
+		errs := make([]error, psLen)
 		for x := 0; x < psLen; x++ {
-			resolution := <-resolutionsChan
-			resolutions[resolution[0].(int)] = resolution[1]
+			select {
+			case resolution := <-resolutionsChan:
+				resolve(resolution)
+				return
+
+			case err := <-errorChan:
+				errs[err[0].(int)] = err[1].(error)
+
+			case <-ctx.Done():
+				cancelPending(promises)
+				return
+			}
 		}
-		resolve(resolutions)
+		reject(&AggregateError{Errors: errs})
 	})
 }
 
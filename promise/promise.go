@@ -0,0 +1,216 @@
+// Package promise provides a Promise typed by the value it resolves to,
+// interoperating with the untyped stream.Promise.
+package promise
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/bino7/stream"
+)
+
+// TypeMismatchError rejects a typed promise made from an untyped value of
+// another type.
+var TypeMismatchError = errors.New("unexpected result type")
+
+// Promise is a value of type T not necessarily known yet. Unlike the
+// untyped stream.Promise, errors only travel through Reject and the error
+// results of callbacks: a T that happens to be an error is a value.
+type Promise[T any] struct {
+	result T
+	err    error
+	done   chan struct{}
+	once   sync.Once
+}
+
+// New returns a promise settled by executor, which runs in its own
+// goroutine. A panic of executor rejects the promise with a
+// *stream.PanicError.
+func New[T any](executor func(resolve func(T), reject func(error))) *Promise[T] {
+	p := &Promise[T]{done: make(chan struct{})}
+	if executor != nil {
+		go func() {
+			defer p.handlePanic()
+			executor(p.Resolve, p.Reject)
+		}()
+	}
+	return p
+}
+
+// Resolve returns a promise fulfilled with v.
+func Resolve[T any](v T) *Promise[T] {
+	p := New[T](nil)
+	p.Resolve(v)
+	return p
+}
+
+// Reject returns a promise rejected with err.
+func Reject[T any](err error) *Promise[T] {
+	p := New[T](nil)
+	p.Reject(err)
+	return p
+}
+
+func (p *Promise[T]) handlePanic() {
+	if r := recover(); r != nil {
+		p.Reject(stream.NewPanicError(r, nil))
+	}
+}
+
+func (p *Promise[T]) settle(v T, err error) {
+	p.once.Do(func() {
+		p.result, p.err = v, err
+		close(p.done)
+	})
+}
+
+// Resolve fulfills the promise with v, unless it is already settled.
+func (p *Promise[T]) Resolve(v T) {
+	p.settle(v, nil)
+}
+
+// Reject rejects the promise with err, unless it is already settled.
+func (p *Promise[T]) Reject(err error) {
+	var zero T
+	p.settle(zero, err)
+}
+
+// Done returns a channel closed once the promise is settled.
+func (p *Promise[T]) Done() <-chan struct{} {
+	return p.done
+}
+
+// Await waits for the promise to be settled and returns its value and error.
+func (p *Promise[T]) Await() (T, error) {
+	<-p.done
+	return p.result, p.err
+}
+
+// AwaitCtx is Await giving up with ctx.Err() when ctx ends first.
+func (p *Promise[T]) AwaitCtx(ctx context.Context) (T, error) {
+	select {
+	case <-p.done:
+		return p.result, p.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// Then returns a promise of fn applied to the value of p. A rejection of p,
+// or an error returned by fn, rejects it.
+func Then[T, U any](p *Promise[T], fn func(T) (U, error)) *Promise[U] {
+	return New(func(resolve func(U), reject func(error)) {
+		v, err := p.Await()
+		if err != nil {
+			reject(err)
+			return
+		}
+		u, err := fn(v)
+		if err != nil {
+			reject(err)
+			return
+		}
+		resolve(u)
+	})
+}
+
+// Catch returns a promise fulfilled like p, or with what fn recovers from
+// a rejection of p. An error returned by fn rejects it.
+func Catch[T any](p *Promise[T], fn func(error) (T, error)) *Promise[T] {
+	return New(func(resolve func(T), reject func(error)) {
+		v, err := p.Await()
+		if err == nil {
+			resolve(v)
+			return
+		}
+		v, err = fn(err)
+		if err != nil {
+			reject(err)
+			return
+		}
+		resolve(v)
+	})
+}
+
+// All waits for all promises to be fulfilled, and is fulfilled with their
+// values in the same order, or for any to be rejected, and is rejected
+// with its reason.
+func All[T any](promises ...*Promise[T]) *Promise[[]T] {
+	return New(func(resolve func([]T), reject func(error)) {
+		type settled struct {
+			index int
+			value T
+			err   error
+		}
+		settledChan := make(chan settled, len(promises))
+		for i, p := range promises {
+			go func(i int, p *Promise[T]) {
+				v, err := p.Await()
+				settledChan <- settled{i, v, err}
+			}(i, p)
+		}
+		values := make([]T, len(promises))
+		for range promises {
+			s := <-settledChan
+			if s.err != nil {
+				reject(s.err)
+				return
+			}
+			values[s.index] = s.value
+		}
+		resolve(values)
+	})
+}
+
+// From returns a typed promise settled like the untyped p. A value that is
+// not a T rejects it with TypeMismatchError; a nil value is the zero T.
+func From[T any](p *stream.Promise) *Promise[T] {
+	return New(func(resolve func(T), reject func(error)) {
+		settleFrom[T](p.Await())(resolve, reject)
+	})
+}
+
+// FromStreams returns a typed promise of the result of the item s is
+// resolving, as s.Await returns it.
+func FromStreams[T any](s stream.Streams) *Promise[T] {
+	return New(func(resolve func(T), reject func(error)) {
+		settleFrom[T](s.Await())(resolve, reject)
+	})
+}
+
+func settleFrom[T any](v interface{}, err error) func(resolve func(T), reject func(error)) {
+	return func(resolve func(T), reject func(error)) {
+		if err != nil {
+			reject(err)
+			return
+		}
+		if v == nil {
+			var zero T
+			resolve(zero)
+			return
+		}
+		t, ok := v.(T)
+		if !ok {
+			var zero T
+			reject(fmt.Errorf("%w: %T is not a %T", TypeMismatchError, v, zero))
+			return
+		}
+		resolve(t)
+	}
+}
+
+// Untyped returns a stream.Promise settled like p. A T that is an error
+// rejects it, as the untyped Promise treats error values as rejections.
+func (p *Promise[T]) Untyped() *stream.Promise {
+	return stream.NewPromise(func(resolve func(interface{}), reject func(error)) {
+		v, err := p.Await()
+		if err != nil {
+			reject(err)
+			return
+		}
+		resolve(v)
+	})
+}
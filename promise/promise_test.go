@@ -0,0 +1,67 @@
+package promise
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/bino7/stream"
+)
+
+func TestThen(t *testing.T) {
+	p := Then(Resolve(21), func(v int) (string, error) {
+		return strconv.Itoa(v * 2), nil
+	})
+	if v, err := p.Await(); err != nil || v != "42" {
+		t.Fatalf("expected 42, got %q, %v", v, err)
+	}
+
+	// an error value is a value, not a rejection
+	failed := errors.New("failed")
+	e, err := Resolve[error](failed).Await()
+	if err != nil || e != failed {
+		t.Fatalf("expected the error as a value, got %v, %v", e, err)
+	}
+
+	recovered := Catch(Then(Resolve(1), func(int) (int, error) {
+		return 0, failed
+	}), func(err error) (int, error) {
+		return -1, nil
+	})
+	if v, err := recovered.Await(); err != nil || v != -1 {
+		t.Fatalf("expected -1, got %v, %v", v, err)
+	}
+}
+
+func TestAll(t *testing.T) {
+	v, err := All(Resolve(1), Resolve(2), Resolve(3)).Await()
+	if err != nil || !reflect.DeepEqual(v, []int{1, 2, 3}) {
+		t.Fatalf("expected [1 2 3], got %v, %v", v, err)
+	}
+	failed := errors.New("failed")
+	if _, err := All(Resolve(1), Reject[int](failed)).Await(); err != failed {
+		t.Fatalf("expected failed, got %v", err)
+	}
+}
+
+func TestInterop(t *testing.T) {
+	if v, err := From[int](stream.Resolve(7)).Await(); err != nil || v != 7 {
+		t.Fatalf("expected 7, got %v, %v", v, err)
+	}
+	if _, err := From[int](stream.Resolve("7")).Await(); !errors.Is(err, TypeMismatchError) {
+		t.Fatalf("expected a type mismatch, got %v", err)
+	}
+	if v, err := Resolve("x").Untyped().Await(); err != nil || v != "x" {
+		t.Fatalf("expected x, got %v, %v", v, err)
+	}
+
+	s := stream.Once("typed", context.Background(), func(v interface{}) (interface{}, error) {
+		return v.(int) + 1, nil
+	})
+	s.Resolve(1)
+	if v, err := FromStreams[int](s).Await(); err != nil || v != 2 {
+		t.Fatalf("expected 2, got %v, %v", v, err)
+	}
+}
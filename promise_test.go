@@ -0,0 +1,161 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPromise_Then(t *testing.T) {
+	p := Resolve(1)
+	a := p.Then(func(v interface{}) interface{} { return v.(int) + 1 })
+	b := p.Then(func(v interface{}) interface{} { return v.(int) * 10 })
+	if v, _ := a.Await(); v != 2 {
+		t.Fatalf("expected 2, got %v", v)
+	}
+	if v, _ := b.Await(); v != 10 {
+		t.Fatalf("expected branches to share the input, got %v", v)
+	}
+	if v, _ := p.Await(); v != 1 {
+		t.Fatalf("expected the promise to keep its result, got %v", v)
+	}
+
+	// a returned promise is flattened
+	flat := p.Then(func(v interface{}) interface{} {
+		return NewPromise(func(resolve func(interface{}), reject func(error)) {
+			time.Sleep(time.Millisecond)
+			resolve(v.(int) + 100)
+		})
+	})
+	if v, err := flat.Await(); err != nil || v != 101 {
+		t.Fatalf("expected 101, got %v, %v", v, err)
+	}
+
+	failed := errors.New("failed")
+	recovered := Reject(failed).
+		Then(func(v interface{}) interface{} {
+			t.Fatal("fulfillment called on a rejected promise")
+			return v
+		}).
+		Then(nil, func(err error) interface{} {
+			if err != failed {
+				t.Fatalf("expected the rejection to pass through, got %v", err)
+			}
+			return "recovered"
+		})
+	if v, err := recovered.Await(); err != nil || v != "recovered" {
+		t.Fatalf("expected recovered, got %v, %v", v, err)
+	}
+
+	caught := Resolve(1).
+		Then(func(v interface{}) interface{} { return failed }).
+		Catch(func(err error) error { return nil })
+	if v, err := caught.Await(); err != nil || v != nil {
+		t.Fatalf("expected Catch to recover, got %v, %v", v, err)
+	}
+}
+
+func TestPromise_All(t *testing.T) {
+	v, err := All(Resolve(1), Resolve(2), Resolve(3)).Await()
+	if err != nil || !reflect.DeepEqual(v, []interface{}{1, 2, 3}) {
+		t.Fatalf("expected [1 2 3], got %v, %v", v, err)
+	}
+	failed := errors.New("failed")
+	if _, err := All(Resolve(1), Reject(failed)).Await(); err != failed {
+		t.Fatalf("expected failed, got %v", err)
+	}
+}
+
+func TestPromise_Context(t *testing.T) {
+	stopped := make(chan struct{})
+	never := func(ctx context.Context, resolve func(interface{}), reject func(error)) {
+		<-ctx.Done()
+		close(stopped)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := NewPromiseCtx(ctx, never)
+	cancel()
+	if _, err := p.Await(); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	<-stopped
+
+	if _, err := NewPromiseCtx(context.Background(), nil).WithTimeout(time.Millisecond).Await(); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	pending := NewPromise(nil)
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer waitCancel()
+	if _, err := pending.AwaitCtx(waitCtx); err != context.DeadlineExceeded {
+		t.Fatalf("expected AwaitCtx to give up, got %v", err)
+	}
+
+	// cancelling a combinator cancels the promises still pending
+	a, b := NewPromiseCtx(context.Background(), nil), Resolve(1)
+	all := All(a, b).WithTimeout(time.Millisecond)
+	if _, err := all.Await(); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if _, err := a.Await(); err != context.Canceled {
+		t.Fatalf("expected the pending promise to be cancelled, got %v", err)
+	}
+	race := Race(NewPromise(nil), NewPromise(nil))
+	race.Cancel()
+	if _, err := race.Await(); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestPromise_Combinators(t *testing.T) {
+	failed := errors.New("failed")
+	v, err := AllSettled(Resolve(1), Reject(failed), Resolve(failed)).Await()
+	expected := []SettledResult{
+		{Status: StatusFulfilled, Value: 1},
+		{Status: StatusRejected, Err: failed},
+		{Status: StatusRejected, Err: failed},
+	}
+	if err != nil || !reflect.DeepEqual(v, expected) {
+		t.Fatalf("expected %v, got %v, %v", expected, v, err)
+	}
+
+	if v, err := Any(Reject(failed), Resolve(2)).Await(); err != nil || v != 2 {
+		t.Fatalf("expected 2, got %v, %v", v, err)
+	}
+	other := errors.New("other")
+	_, err = Any(Reject(failed), Reject(other)).Await()
+	var aggregate *AggregateError
+	if !errors.As(err, &aggregate) || !reflect.DeepEqual(aggregate.Errors, []error{failed, other}) {
+		t.Fatalf("expected an AggregateError of both rejections, got %v", err)
+	}
+
+	calls := 0
+	if _, err := Reject(failed).Finally(func() { calls++ }).Await(); err != failed {
+		t.Fatalf("expected Finally to keep the rejection, got %v", err)
+	}
+	if v, _ := Resolve(3).Finally(func() { calls++ }).Await(); v != 3 || calls != 2 {
+		t.Fatalf("expected Finally to keep the value and run twice, got %v after %d", v, calls)
+	}
+}
+
+func TestLazy(t *testing.T) {
+	started := make(chan struct{}, 1)
+	p := Lazy(func(resolve func(interface{}), reject func(error)) {
+		started <- struct{}{}
+		resolve(1)
+	})
+	select {
+	case <-started:
+		t.Fatal("expected the executor to wait for the first Await or Then")
+	case <-time.After(10 * time.Millisecond):
+	}
+	next := p.Then(func(v interface{}) interface{} { return v.(int) + 1 })
+	if v, err := next.Await(); err != nil || v != 2 {
+		t.Fatalf("expected 2, got %v, %v", v, err)
+	}
+	if v, _ := p.Await(); v != 1 || len(started) != 1 {
+		t.Fatalf("expected the executor to run once, got %v", v)
+	}
+}
@@ -0,0 +1,262 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	DuplicateNameError  = errors.New("name already registered")
+	UnknownHandlerError = errors.New("unknown handler")
+)
+
+// Kinds of registry entries, as reported by Registry.List.
+const (
+	HandlerEntry    = "handler"
+	HookEntry       = "hook"
+	BeforeEntry     = "before"
+	AfterEntry      = "after"
+	OnErrorEntry    = "onError"
+	MiddlewareEntry = "middleware"
+)
+
+// RegistryEntry describes one registered name.
+type RegistryEntry struct {
+	Kind string
+	Name string
+}
+
+// Registry holds handlers, hooks and middleware by name. Before and After
+// hooks are registered under the name of the handler they wrap, OnError
+// hooks under the name of the Streams they watch; plain hooks are free
+// standing functions a configuration can refer to. Registering a name
+// twice for the same kind is an error.
+type Registry struct {
+	handlers   map[string]interface{}
+	hooks      map[string]HandleFunc
+	before     map[string]HandleFunc
+	after      map[string]HandleFunc
+	onError    map[string]ErrorHandleFunc
+	middleware map[string]Middleware
+	global     []Middleware
+	mutex      sync.RWMutex
+}
+
+// DefaultRegistry is used by Streams whose context carries no Registry.
+var DefaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{
+		handlers:   make(map[string]interface{}),
+		hooks:      make(map[string]HandleFunc),
+		before:     make(map[string]HandleFunc),
+		after:      make(map[string]HandleFunc),
+		onError:    make(map[string]ErrorHandleFunc),
+		middleware: make(map[string]Middleware),
+	}
+}
+
+type registryKey struct{}
+
+// WithRegistry returns a context whose Streams resolve names and hooks from r.
+func WithRegistry(ctx context.Context, r *Registry) context.Context {
+	return context.WithValue(ctx, registryKey{}, r)
+}
+
+// RegistryFrom returns the Registry carried by ctx, or DefaultRegistry.
+func RegistryFrom(ctx context.Context) *Registry {
+	if ctx != nil {
+		if r, ok := ctx.Value(registryKey{}).(*Registry); ok {
+			return r
+		}
+	}
+	return DefaultRegistry
+}
+
+func duplicate(kind, name string) error {
+	return fmt.Errorf("%w: %s %q", DuplicateNameError, kind, name)
+}
+
+// RegisterHandler registers anything a Streams chain accepts. An empty name
+// is taken from the handler with HandlerName.
+func (r *Registry) RegisterHandler(name string, handler interface{}) error {
+	if name == "" {
+		name = HandlerName(handler)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.handlers[name]; ok {
+		return duplicate(HandlerEntry, name)
+	}
+	r.handlers[name] = handler
+	return nil
+}
+
+// RegisterHook registers a free standing hook function.
+func (r *Registry) RegisterHook(name string, hook HandleFunc) error {
+	return r.register(r.hooks, HookEntry, name, hook)
+}
+
+// RegisterBefore registers the hook run before the named handler.
+func (r *Registry) RegisterBefore(handler string, hook HandleFunc) error {
+	return r.register(r.before, BeforeEntry, handler, hook)
+}
+
+// RegisterAfter registers the hook run after the named handler.
+func (r *Registry) RegisterAfter(handler string, hook HandleFunc) error {
+	return r.register(r.after, AfterEntry, handler, hook)
+}
+
+func (r *Registry) register(m map[string]HandleFunc, kind, name string, hook HandleFunc) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := m[name]; ok {
+		return duplicate(kind, name)
+	}
+	m[name] = hook
+	return nil
+}
+
+// RegisterOnError registers the hook told about every failure of the named Streams.
+func (r *Registry) RegisterOnError(streams string, hook ErrorHandleFunc) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.onError[streams]; ok {
+		return duplicate(OnErrorEntry, streams)
+	}
+	r.onError[streams] = hook
+	return nil
+}
+
+// RegisterMiddleware registers a named Middleware.
+func (r *Registry) RegisterMiddleware(name string, mw Middleware) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.middleware[name]; ok {
+		return duplicate(MiddlewareEntry, name)
+	}
+	r.middleware[name] = mw
+	return nil
+}
+
+// Use adds middleware applied to every handler of the Streams resolving
+// from r, outside the middleware of the Streams and of the handler.
+func (r *Registry) Use(mws ...Middleware) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.global = append(r.global, mws...)
+}
+
+// Global returns the middleware added with Use.
+func (r *Registry) Global() []Middleware {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return append([]Middleware{}, r.global...)
+}
+
+// Handler implements HandlerLookup.
+func (r *Registry) Handler(name string) (interface{}, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	h, ok := r.handlers[name]
+	return h, ok
+}
+
+// Hook implements HandlerLookup. Registered hooks come first, then
+// handlers that have the shape of a HandleFunc.
+func (r *Registry) Hook(name string) (HandleFunc, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if hook, ok := r.hooks[name]; ok {
+		return hook, true
+	}
+	return HandlerMap(r.handlers).Hook(name)
+}
+
+// Before returns the hook registered to run before the named handler.
+func (r *Registry) Before(handler string) (HandleFunc, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	hook, ok := r.before[handler]
+	return hook, ok
+}
+
+// After returns the hook registered to run after the named handler.
+func (r *Registry) After(handler string) (HandleFunc, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	hook, ok := r.after[handler]
+	return hook, ok
+}
+
+// OnError returns the hook registered for the named Streams.
+func (r *Registry) OnError(streams string) (ErrorHandleFunc, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	hook, ok := r.onError[streams]
+	return hook, ok
+}
+
+// Middleware returns the named Middleware.
+func (r *Registry) Middleware(name string) (Middleware, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	mw, ok := r.middleware[name]
+	return mw, ok
+}
+
+// Handlers returns the names of the registered handlers, sorted.
+func (r *Registry) Handlers() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return sortedKeys(r.handlers)
+}
+
+// List returns every registered name with its kind, sorted by kind then name.
+func (r *Registry) List() []RegistryEntry {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	entries := make([]RegistryEntry, 0)
+	add := func(kind string, names []string) {
+		for _, name := range names {
+			entries = append(entries, RegistryEntry{Kind: kind, Name: name})
+		}
+	}
+	add(HandlerEntry, sortedKeys(r.handlers))
+	add(HookEntry, sortedKeys(r.hooks))
+	add(BeforeEntry, sortedKeys(r.before))
+	add(AfterEntry, sortedKeys(r.after))
+	add(OnErrorEntry, sortedKeys(r.onError))
+	add(MiddlewareEntry, sortedKeys(r.middleware))
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Kind < entries[j].Kind
+	})
+	return entries
+}
+
+func sortedKeys(m interface{}) []string {
+	keys := make([]string, 0)
+	switch m := m.(type) {
+	case map[string]interface{}:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]HandleFunc:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]ErrorHandleFunc:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]Middleware:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
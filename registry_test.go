@@ -0,0 +1,70 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	double := func(v interface{}) (interface{}, error) {
+		return v.(int) * 2, nil
+	}
+	if err := r.RegisterHandler("double", double); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RegisterHandler("double", double); !errors.Is(err, DuplicateNameError) {
+		t.Fatalf("expected a duplicate name error, got %v", err)
+	}
+	// a closure has no stable func name, the registry knows it by the handler's name
+	offset := 1
+	if err := r.RegisterBefore("double", func(v interface{}) (interface{}, error) {
+		return v.(int) + offset, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var failures []error
+	if err := r.RegisterOnError("registry", func(err error) error {
+		failures = append(failures, err)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithRegistry(context.Background(), r)
+	s := Once("registry", ctx, &Handler{Name: "double", Apply: double})
+	s.Resolve(1)
+	if v, err := s.Await(); err != nil || v != 4 {
+		t.Fatalf("expected 4, got %v, %v", v, err)
+	}
+
+	// a bare func referenced by its registered name gets the hooks of that name
+	s = Once("registry", ctx, "double")
+	s.Resolve(1)
+	if v, err := s.Await(); err != nil || v != 4 {
+		t.Fatalf("expected 4, got %v, %v", v, err)
+	}
+
+	s = Once("registry", ctx, "missing")
+	s.Resolve(1)
+	if _, err := s.Await(); !errors.Is(err, UnknownHandlerError) {
+		t.Fatalf("expected an unknown handler error, got %v", err)
+	}
+	if len(failures) != 1 || !errors.Is(failures[0], UnknownHandlerError) {
+		t.Fatalf("expected the OnError hook to see the failure, got %v", failures)
+	}
+
+	if names := r.Handlers(); !reflect.DeepEqual(names, []string{"double"}) {
+		t.Fatalf("unexpected handlers %v", names)
+	}
+	expected := []RegistryEntry{
+		{Kind: BeforeEntry, Name: "double"},
+		{Kind: HandlerEntry, Name: "double"},
+		{Kind: OnErrorEntry, Name: "registry"},
+	}
+	if entries := r.List(); !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected %v, got %v", expected, entries)
+	}
+}
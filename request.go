@@ -0,0 +1,106 @@
+package stream
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// DefaultRequestTimeout bounds a Request whose context has no deadline.
+var DefaultRequestTimeout = 30 * time.Second
+
+// Envelope carries an item through a Streams chain together with the
+// correlation ID of the request waiting for its result. The handlers see
+// only Item; once the chain has run, the Streams replies to the request
+// with the final result or error.
+type Envelope struct {
+	CorrelationID string
+	Item          interface{}
+}
+
+type pendingReply struct {
+	promise *Promise
+	cancel  context.CancelFunc
+}
+
+var (
+	replies      = make(map[string]*pendingReply)
+	repliesMutex sync.Mutex
+)
+
+func newCorrelationID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Request sends item to target and returns a promise of the result of
+// target's handler chain for that item. The promise is rejected with
+// ctx.Err() if ctx ends first, or after DefaultRequestTimeout when ctx has
+// no deadline; a late reply is then ignored. It is rejected with
+// StreamsClosedError when target no longer takes input.
+func Request(ctx context.Context, target Streams, item interface{}) *Promise {
+	var cancel context.CancelFunc
+	if _, ok := ctx.Deadline(); ok {
+		ctx, cancel = context.WithCancel(ctx)
+	} else {
+		ctx, cancel = context.WithTimeout(ctx, DefaultRequestTimeout)
+	}
+	id, promise := expectReply(cancel)
+
+	go func() {
+		<-ctx.Done()
+		Reply(id, nil, ctx.Err())
+	}()
+
+	env := Envelope{CorrelationID: id, Item: item}
+	if in := target.Input(); in != nil {
+		go func() {
+			if err := sendTo(ctx, in, env); err == StreamsClosedError {
+				Reply(id, nil, err)
+			}
+		}()
+	} else {
+		go target.Resolve(env)
+	}
+	return promise
+}
+
+// expectReply registers a promise waiting for the reply with a new
+// correlation ID. cancel is called once the reply came.
+func expectReply(cancel context.CancelFunc) (string, *Promise) {
+	id := newCorrelationID()
+	promise := NewPromise(nil)
+	repliesMutex.Lock()
+	replies[id] = &pendingReply{promise: promise, cancel: cancel}
+	repliesMutex.Unlock()
+	return id, promise
+}
+
+// Reply settles the pending request with the given correlation ID, and
+// reports whether one was still waiting.
+func Reply(correlationID string, result interface{}, err error) bool {
+	repliesMutex.Lock()
+	pending, ok := replies[correlationID]
+	delete(replies, correlationID)
+	repliesMutex.Unlock()
+	if !ok {
+		return false
+	}
+	pending.cancel()
+	if err != nil {
+		pending.promise.Reject(err)
+	} else {
+		pending.promise.Resolve(result)
+	}
+	return true
+}
+
+// PendingRequests returns the number of requests still waiting for a reply.
+func PendingRequests() int {
+	repliesMutex.Lock()
+	defer repliesMutex.Unlock()
+	return len(replies)
+}
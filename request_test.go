@@ -0,0 +1,73 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRequest(t *testing.T) {
+	s := With("request", context.Background(), 10, func(v interface{}) (interface{}, error) {
+		return v.(int) * 2, nil
+	})
+	defer s.Shutdown(context.Background())
+	v, err := Request(context.Background(), s, 21).Await()
+	if err != nil || v != 42 {
+		t.Fatalf("expected 42, got %v, %v", v, err)
+	}
+}
+
+func TestRequest_Timeout(t *testing.T) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	s := With("request-timeout", context.Background(), 10, func(v interface{}) (interface{}, error) {
+		started <- struct{}{}
+		<-release
+		return v, nil
+	})
+	defer s.Shutdown(context.Background())
+	before := PendingRequests()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	p := Request(ctx, s, 1)
+	if _, err := p.Await(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	if n := PendingRequests(); n != before {
+		t.Fatalf("expected the pending request to be removed, got %d pending", n)
+	}
+
+	// the reply coming after the timeout is ignored
+	<-started
+	close(release)
+	_, _ = s.Await()
+	if _, err := p.Await(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the late reply to be ignored, got %v", err)
+	}
+}
+
+func TestReply_Late(t *testing.T) {
+	id, p := expectReply(func() {})
+	if !Reply(id, 1, nil) {
+		t.Fatal("expected the request to be waiting")
+	}
+	if Reply(id, 2, nil) {
+		t.Fatal("expected a second reply to be ignored")
+	}
+	if v, err := p.Await(); err != nil || v != 1 {
+		t.Fatalf("expected 1, got %v, %v", v, err)
+	}
+}
+
+func TestRequest_Closed(t *testing.T) {
+	s := With("request-closed", context.Background(), 10, func(v interface{}) (interface{}, error) {
+		return v, nil
+	})
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Request(context.Background(), s, 1).Await(); err != StreamsClosedError {
+		t.Fatalf("expected StreamsClosedError, got %v", err)
+	}
+}
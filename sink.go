@@ -0,0 +1,455 @@
+package stream
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TwoPhaseSink is a sink written to in transactions that commit with the
+// pipeline's checkpoints. Writes go to the transaction opened by the last
+// Begin. PreCommit must make the transaction durable enough that Commit
+// cannot fail for lack of data; Commit and Abort may be called again for
+// the same transaction after a restore and should then be no-ops.
+type TwoPhaseSink interface {
+	Begin(txID int64) error
+	Write(item interface{}) error
+	PreCommit(txID int64) error
+	Commit(txID int64) error
+	Abort(txID int64) error
+}
+
+// RecoverableSink is a TwoPhaseSink that can list the transactions it
+// pre-committed but did not commit or abort yet, such as the ones left by a
+// crash. Deliver commits those belonging to the restored checkpoint and
+// aborts the others before it begins.
+type RecoverableSink interface {
+	TwoPhaseSink
+	Pending() ([]int64, error)
+}
+
+type sinkCommit struct {
+	txID    int64
+	promise *Promise
+}
+
+// Deliver writes s to sink exactly once per checkpoint: each barrier
+// pre-commits the open transaction and starts the next one, and a
+// transaction is committed only once its checkpoint is on disk, or aborted
+// if the checkpoint fails. The sources take a final checkpoint when they
+// close, which commits the last items; a transaction still open after it
+// is aborted. Deliver counts as a sink of the Checkpointer.
+// The returned Stream carries the sink's errors, must be drained like the
+// error Stream of Handle, and closes once every transaction has settled.
+//
+// The transaction pre-committed at a barrier has an ID no greater than the
+// barrier's and greater than the previous barrier's, so after a restore the
+// pending transactions of a RecoverableSink up to the restored checkpoint ID
+// are exactly the ones that checkpoint covers.
+func (c *Checkpointer) Deliver(s Stream, sink TwoPhaseSink) Stream {
+	k := c.addSink()
+	c.mutex.Lock()
+	txID := c.nextID + 1
+	c.mutex.Unlock()
+
+	errs := make(chan interface{})
+	var restored int64
+	if c.restored != nil {
+		restored = c.restored.ID
+	}
+	commits := make(chan sinkCommit, maxPendingCheckpoints)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for commit := range commits {
+			if _, err := commit.promise.Await(); err != nil {
+				if err := sink.Abort(commit.txID); err != nil {
+					errs <- err
+				}
+				continue
+			}
+			if err := sink.Commit(commit.txID); err != nil {
+				errs <- err
+			}
+		}
+	}()
+
+	go func() {
+		if r, ok := sink.(RecoverableSink); ok {
+			for _, err := range recoverSink(r, restored) {
+				errs <- err
+			}
+		}
+		open, written := true, 0
+		if err := sink.Begin(txID); err != nil {
+			open = false
+			errs <- err
+		}
+		for v := range s {
+			b, ok := v.(Barrier)
+			if !ok {
+				if !open {
+					continue
+				}
+				if err := sink.Write(v); err != nil {
+					errs <- err
+				}
+				written++
+				continue
+			}
+			p := c.promise(b)
+			if p == nil {
+				c.ack(k, b)
+				continue
+			}
+			if !open {
+				c.fail(b, fmt.Errorf("sink: transaction %d is not open", txID))
+			} else if err := sink.PreCommit(txID); err != nil {
+				_ = sink.Abort(txID)
+				c.fail(b, err)
+				errs <- err
+			} else {
+				commits <- sinkCommit{txID: txID, promise: p}
+				c.ack(k, b)
+			}
+			txID = b.ID + 1
+			open, written = true, 0
+			if err := sink.Begin(txID); err != nil {
+				open = false
+				errs <- err
+			}
+		}
+		c.closeSink(k)
+		// the final checkpoint of the sources has covered every item
+		if open {
+			if err := sink.Abort(txID); err != nil {
+				errs <- err
+			}
+			if written > 0 {
+				errs <- fmt.Errorf("sink: %d items after the last checkpoint were not delivered", written)
+			}
+		}
+		close(commits)
+		wg.Wait()
+		close(errs)
+	}()
+	return Stream(errs)
+}
+
+// recoverSink commits the pending transactions of sink up to restored and
+// aborts the later ones.
+func recoverSink(sink RecoverableSink, restored int64) []error {
+	pending, err := sink.Pending()
+	if err != nil {
+		return []error{err}
+	}
+	errs := make([]error, 0)
+	for _, txID := range pending {
+		if txID <= restored {
+			err = sink.Commit(txID)
+		} else {
+			err = sink.Abort(txID)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// IdempotencyKeyFunc returns the key identifying an item for deduplication.
+type IdempotencyKeyFunc func(interface{}) string
+
+// IdempotentSink drops items whose idempotency key has already been
+// committed, so a replay after a failure does not write them twice.
+// Committed keys are kept in a StateStore, which should be a
+// FileStateStore for the guarantee to survive a restart, and are written in
+// one PutAll when it is a BatchStateStore. Keys are stored right after the
+// wrapped sink commits; a crash between the two can still let the items of
+// that one transaction through again.
+type IdempotentSink struct {
+	sink    TwoPhaseSink
+	key     IdempotencyKeyFunc
+	seen    StateStore
+	current int64
+	staged  map[int64]map[string]struct{}
+	mutex   sync.Mutex
+}
+
+func NewIdempotentSink(sink TwoPhaseSink, key IdempotencyKeyFunc, seen StateStore) *IdempotentSink {
+	if seen == nil {
+		seen = NewMemoryStateStore()
+	}
+	return &IdempotentSink{
+		sink:   sink,
+		key:    key,
+		seen:   seen,
+		staged: make(map[int64]map[string]struct{}),
+	}
+}
+
+func (s *IdempotentSink) Begin(txID int64) error {
+	s.mutex.Lock()
+	s.current = txID
+	s.staged[txID] = make(map[string]struct{})
+	s.mutex.Unlock()
+	return s.sink.Begin(txID)
+}
+
+func (s *IdempotentSink) Write(item interface{}) error {
+	k := s.key(item)
+	s.mutex.Lock()
+	for _, keys := range s.staged {
+		if _, ok := keys[k]; ok {
+			s.mutex.Unlock()
+			return nil
+		}
+	}
+	if _, ok, err := s.seen.Get(k); ok || err != nil {
+		s.mutex.Unlock()
+		return err
+	}
+	if s.staged[s.current] == nil {
+		s.staged[s.current] = make(map[string]struct{})
+	}
+	s.staged[s.current][k] = struct{}{}
+	s.mutex.Unlock()
+	return s.sink.Write(item)
+}
+
+func (s *IdempotentSink) PreCommit(txID int64) error {
+	return s.sink.PreCommit(txID)
+}
+
+func (s *IdempotentSink) Commit(txID int64) error {
+	if err := s.sink.Commit(txID); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	keys := s.staged[txID]
+	delete(s.staged, txID)
+	s.mutex.Unlock()
+	if len(keys) == 0 {
+		return nil
+	}
+	if batch, ok := s.seen.(BatchStateStore); ok {
+		values := make(map[string]interface{}, len(keys))
+		for k := range keys {
+			values[k] = true
+		}
+		return batch.PutAll(values)
+	}
+	for k := range keys {
+		if err := s.seen.Put(k, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pending returns the pending transactions of the wrapped sink, or none
+// when it is not a RecoverableSink.
+func (s *IdempotentSink) Pending() ([]int64, error) {
+	if r, ok := s.sink.(RecoverableSink); ok {
+		return r.Pending()
+	}
+	return nil, nil
+}
+
+func (s *IdempotentSink) Abort(txID int64) error {
+	s.mutex.Lock()
+	delete(s.staged, txID)
+	s.mutex.Unlock()
+	return s.sink.Abort(txID)
+}
+
+// FileSink is a RecoverableSink appending items to a file as JSON lines.
+// Each transaction is written to its own side file, which Commit appends to
+// the target file and removes. Before appending, Commit records the size of
+// the target in a marker file, so a Commit interrupted by a crash truncates
+// the partial append away when it is run again instead of appending twice.
+type FileSink struct {
+	path  string
+	file  *os.File
+	w     *bufio.Writer
+	mutex sync.Mutex
+}
+
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+func (f *FileSink) txPath(txID int64) string {
+	return fmt.Sprintf("%s.tx-%d", f.path, txID)
+}
+
+func (f *FileSink) markerPath(txID int64) string {
+	return f.txPath(txID) + ".commit"
+}
+
+func (f *FileSink) Begin(txID int64) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	file, err := os.Create(f.txPath(txID))
+	if err != nil {
+		return err
+	}
+	f.file = file
+	f.w = bufio.NewWriter(file)
+	return nil
+}
+
+func (f *FileSink) Write(item interface{}) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.w == nil {
+		return fmt.Errorf("file sink %s: no open transaction", f.path)
+	}
+	return json.NewEncoder(f.w).Encode(item)
+}
+
+func (f *FileSink) PreCommit(txID int64) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file == nil || f.file.Name() != f.txPath(txID) {
+		return fmt.Errorf("file sink %s: transaction %d is not open", f.path, txID)
+	}
+	file, w := f.file, f.w
+	f.file, f.w = nil, nil
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (f *FileSink) Commit(txID int64) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	tx, err := os.Open(f.txPath(txID))
+	if os.IsNotExist(err) {
+		return removeIfExists(f.markerPath(txID))
+	}
+	if err != nil {
+		return err
+	}
+	defer tx.Close()
+	target, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	offset, err := f.commitOffset(txID, target)
+	if err == nil {
+		err = target.Truncate(offset)
+	}
+	if err == nil {
+		_, err = target.Seek(offset, io.SeekStart)
+	}
+	if err == nil {
+		_, err = io.Copy(target, tx)
+	}
+	if err == nil {
+		err = target.Sync()
+	}
+	if err != nil {
+		target.Close()
+		return err
+	}
+	if err := target.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(tx.Name()); err != nil {
+		return err
+	}
+	return removeIfExists(f.markerPath(txID))
+}
+
+// commitOffset returns the size target had before txID was first appended,
+// recording it in the marker file when this is the first attempt.
+func (f *FileSink) commitOffset(txID int64, target *os.File) (int64, error) {
+	data, err := ioutil.ReadFile(f.markerPath(txID))
+	if err == nil {
+		return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	}
+	if !os.IsNotExist(err) {
+		return 0, err
+	}
+	info, err := target.Stat()
+	if err != nil {
+		return 0, err
+	}
+	marker, err := os.Create(f.markerPath(txID))
+	if err != nil {
+		return 0, err
+	}
+	if _, err := fmt.Fprintf(marker, "%d\n", info.Size()); err != nil {
+		marker.Close()
+		return 0, err
+	}
+	if err := marker.Sync(); err != nil {
+		marker.Close()
+		return 0, err
+	}
+	return info.Size(), marker.Close()
+}
+
+func (f *FileSink) Abort(txID int64) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file != nil && f.file.Name() == f.txPath(txID) {
+		f.file.Close()
+		f.file, f.w = nil, nil
+	}
+	return removeIfExists(f.txPath(txID))
+}
+
+// Pending returns the transactions whose side file is still there, oldest
+// first. Transactions with a commit marker were being committed.
+func (f *FileSink) Pending() ([]int64, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	infos, err := ioutil.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return nil, err
+	}
+	pending := make([]int64, 0)
+	prefix := filepath.Base(f.path) + ".tx-"
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasPrefix(info.Name(), prefix) {
+			continue
+		}
+		txID, err := strconv.ParseInt(strings.TrimPrefix(info.Name(), prefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		if f.file != nil && f.file.Name() == f.txPath(txID) {
+			continue
+		}
+		pending = append(pending, txID)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i] < pending[j]
+	})
+	return pending, nil
+}
+
+func removeIfExists(name string) error {
+	err := os.Remove(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
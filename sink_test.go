@@ -0,0 +1,221 @@
+package stream
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingSink records the calls made to it.
+type recordingSink struct {
+	calls []string
+	mutex sync.Mutex
+}
+
+func (r *recordingSink) record(call string, txID int64) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.calls = append(r.calls, fmt.Sprintf("%s %d", call, txID))
+	return nil
+}
+
+func (r *recordingSink) Begin(txID int64) error       { return r.record("begin", txID) }
+func (r *recordingSink) Write(item interface{}) error { return nil }
+func (r *recordingSink) PreCommit(txID int64) error   { return r.record("precommit", txID) }
+func (r *recordingSink) Commit(txID int64) error      { return r.record("commit", txID) }
+func (r *recordingSink) Abort(txID int64) error       { return r.record("abort", txID) }
+
+func (r *recordingSink) Calls() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string{}, r.calls...)
+}
+
+func readLines(t *testing.T, path string) []string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Fields(string(data))
+}
+
+func TestDeliver_CommitOnCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewCheckpointer(filepath.Join(dir, "checkpoints"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "out.jsonl")
+	in := New(0)
+	errs := c.Deliver(c.Source("in", in), NewFileSink(path))
+	in <- 1
+	in <- 2
+	if _, err := c.Checkpoint().Await(); err != nil {
+		t.Fatal(err)
+	}
+	in <- 3
+	close(in)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if lines := readLines(t, path); !reflect.DeepEqual(lines, []string{"1", "2", "3"}) {
+		t.Fatalf("unexpected output %v", lines)
+	}
+	if pending, _ := NewFileSink(path).Pending(); len(pending) != 0 {
+		t.Fatalf("expected no pending transaction, got %v", pending)
+	}
+}
+
+func TestDeliver_Rerun(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.jsonl")
+	run := func() {
+		c, err := NewCheckpointer(filepath.Join(dir, "checkpoints"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := New(0)
+		errs := c.Deliver(c.Source("in", in), NewFileSink(path))
+		go func() {
+			in <- 1
+			in <- 2
+			if _, err := c.Checkpoint().Await(); err != nil {
+				t.Error(err)
+			}
+			in <- 3
+			close(in)
+		}()
+		for err := range errs {
+			t.Fatal(err)
+		}
+	}
+	run()
+	run()
+	if lines := readLines(t, path); !reflect.DeepEqual(lines, []string{"1", "2", "3"}) {
+		t.Fatalf("expected a re-run to deliver nothing again, got %v", lines)
+	}
+}
+
+func TestDeliver_AbortOnFailedCheckpoint(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "checkpoints")
+	c, err := NewCheckpointer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The checkpoint cannot be written once its directory is gone.
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	sink := &recordingSink{}
+	in := New(0)
+	errs := c.Deliver(c.Source("in", in), sink)
+	in <- 1
+	if _, err := c.Checkpoint().Await(); err == nil {
+		t.Fatal("expected the checkpoint to fail")
+	}
+	close(in)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	// the final checkpoint fails too
+	calls := sink.Calls()
+	if !contains(calls, "abort 1") || !contains(calls, "abort 2") || contains(calls, "commit 1") || contains(calls, "commit 2") {
+		t.Fatalf("expected transactions 1 and 2 aborted, got %v", calls)
+	}
+}
+
+func contains(items []string, item string) bool {
+	for _, v := range items {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
+
+func TestIdempotentSink_Replay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.jsonl")
+	seen := NewMemoryStateStore()
+	sink := NewIdempotentSink(NewFileSink(path), func(v interface{}) string {
+		return fmt.Sprint(v)
+	}, seen)
+	write := func(txID int64, items ...interface{}) {
+		if err := sink.Begin(txID); err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range items {
+			if err := sink.Write(item); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := sink.PreCommit(txID); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Commit(txID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(1, "a", "b", "a")
+	write(2, "a", "b", "c")
+	if lines := readLines(t, path); !reflect.DeepEqual(lines, []string{`"a"`, `"b"`, `"c"`}) {
+		t.Fatalf("unexpected output %v", lines)
+	}
+	if _, ok, _ := seen.Get("c"); !ok {
+		t.Fatal("expected the committed keys to be stored")
+	}
+}
+
+func TestFileSink_Recover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.jsonl")
+	sink := NewFileSink(path)
+	for txID, item := range map[int64]string{1: "a", 2: "b", 3: "c"} {
+		if err := sink.Begin(txID); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Write(item); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.PreCommit(txID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Commit(1); err != nil {
+		t.Fatal(err)
+	}
+	// A crash in the middle of committing 2 leaves its marker and a partial append.
+	if err := ioutil.WriteFile(sink.markerPath(2), []byte("4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	target, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = target.WriteString(`"b`)
+	target.Close()
+
+	sink = NewFileSink(path)
+	pending, err := sink.Pending()
+	if err != nil || !reflect.DeepEqual(pending, []int64{2, 3}) {
+		t.Fatalf("expected transactions 2 and 3 pending, got %v %v", pending, err)
+	}
+	if errs := recoverSink(sink, 2); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if lines := readLines(t, path); !reflect.DeepEqual(lines, []string{`"a"`, `"b"`}) {
+		t.Fatalf("unexpected output %v", lines)
+	}
+	if pending, _ := sink.Pending(); len(pending) != 0 {
+		t.Fatalf("expected no pending transaction, got %v", pending)
+	}
+	if err := sink.Commit(2); err != nil {
+		t.Fatal(err)
+	}
+	if lines := readLines(t, path); len(lines) != 2 {
+		t.Fatalf("expected a repeated commit to be a no-op, got %v", lines)
+	}
+}
@@ -0,0 +1,218 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+)
+
+// StateStore holds operator state by key. Range visits keys in sorted order
+// and stops when apply returns false.
+type StateStore interface {
+	Get(key string) (interface{}, bool, error)
+	Put(key string, value interface{}) error
+	Delete(key string) error
+	Range(apply func(key string, value interface{}) bool) error
+}
+
+// BatchStateStore is a StateStore that can put several values in one write.
+type BatchStateStore interface {
+	StateStore
+	PutAll(values map[string]interface{}) error
+}
+
+// MemoryStateStore is a StateStore kept in memory.
+type MemoryStateStore struct {
+	values map[string]interface{}
+	mutex  sync.RWMutex
+}
+
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{
+		values: make(map[string]interface{}),
+	}
+}
+
+func (m *MemoryStateStore) Get(key string) (interface{}, bool, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	v, ok := m.values[key]
+	return v, ok, nil
+}
+
+func (m *MemoryStateStore) Put(key string, value interface{}) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.values[key] = value
+	return nil
+}
+
+func (m *MemoryStateStore) PutAll(values map[string]interface{}) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for k, v := range values {
+		m.values[k] = v
+	}
+	return nil
+}
+
+func (m *MemoryStateStore) Delete(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.values, key)
+	return nil
+}
+
+func (m *MemoryStateStore) Range(apply func(key string, value interface{}) bool) error {
+	m.mutex.RLock()
+	keys := make([]string, 0, len(m.values))
+	for k := range m.values {
+		keys = append(keys, k)
+	}
+	m.mutex.RUnlock()
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, ok, _ := m.Get(k)
+		if !ok {
+			continue
+		}
+		if !apply(k, v) {
+			break
+		}
+	}
+	return nil
+}
+
+// FileStateStore is a StateStore kept in memory and written through to a JSON
+// file on every change, so its content survives a restart. Values are read
+// back as decoded JSON, numbers as float64.
+type FileStateStore struct {
+	*MemoryStateStore
+	path  string
+	write sync.Mutex
+}
+
+func NewFileStateStore(path string) (*FileStateStore, error) {
+	f := &FileStateStore{
+		MemoryStateStore: NewMemoryStateStore(),
+		path:             path,
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &f.values); err != nil {
+			return nil, fmt.Errorf("state store %s: %v", path, err)
+		}
+	}
+	return f, nil
+}
+
+func (f *FileStateStore) Put(key string, value interface{}) error {
+	_ = f.MemoryStateStore.Put(key, value)
+	return f.flush()
+}
+
+func (f *FileStateStore) PutAll(values map[string]interface{}) error {
+	_ = f.MemoryStateStore.PutAll(values)
+	return f.flush()
+}
+
+func (f *FileStateStore) Delete(key string) error {
+	_ = f.MemoryStateStore.Delete(key)
+	return f.flush()
+}
+
+func (f *FileStateStore) flush() error {
+	f.write.Lock()
+	defer f.write.Unlock()
+	f.mutex.RLock()
+	data, err := json.Marshal(f.values)
+	f.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+func stateKey(apply KeySelectorFunc, item interface{}) string {
+	if apply == nil {
+		return ""
+	}
+	return fmt.Sprint(apply(item))
+}
+
+// ProcessWithState gives every key selected by key its own state in store.
+// apply may emit any number of values per item; a nil new state deletes the
+// key. Errors from apply or from the store are sent to the second Stream.
+func (s Stream) ProcessWithState(key KeySelectorFunc, store StateStore, apply ProcessFunc) (Stream, Stream) {
+	return s.processWithState(key, store, apply, nil)
+}
+
+func (s Stream) processWithState(key KeySelectorFunc, store StateStore, apply ProcessFunc, onBarrier func(Barrier)) (Stream, Stream) {
+	out := make(chan interface{})
+	errs := make(chan interface{})
+	go func() {
+		emit := func(v interface{}) {
+			out <- v
+		}
+		for item := range s {
+			if b, ok := item.(Barrier); ok {
+				if onBarrier != nil {
+					onBarrier(b)
+				}
+				out <- item
+				continue
+			}
+			k := stateKey(key, item)
+			state, _, err := store.Get(k)
+			if err != nil {
+				errs <- err
+				continue
+			}
+			state, err = apply(state, item, emit)
+			if err != nil {
+				errs <- err
+				continue
+			}
+			if state == nil {
+				err = store.Delete(k)
+			} else {
+				err = store.Put(k, state)
+			}
+			if err != nil {
+				errs <- err
+			}
+		}
+		close(out)
+		close(errs)
+	}()
+	return Stream(out), Stream(errs)
+}
+
+// MapWithState is ProcessWithState for the common case of one output per item.
+func (s Stream) MapWithState(key KeySelectorFunc, store StateStore, apply StatefulFunc) (Stream, Stream) {
+	return s.ProcessWithState(key, store, statefulProcess(apply))
+}
+
+func statefulProcess(apply StatefulFunc) ProcessFunc {
+	return func(state interface{}, item interface{}, emit ConsumeFunc) (interface{}, error) {
+		state, v, err := apply(state, item)
+		if err != nil {
+			return nil, err
+		}
+		emit(v)
+		return state, nil
+	}
+}
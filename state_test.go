@@ -0,0 +1,48 @@
+package stream
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func runningSum(state interface{}, item interface{}) (interface{}, interface{}, error) {
+	sum, _ := state.(float64)
+	sum += float64(item.(int))
+	return sum, sum, nil
+}
+
+func parity(v interface{}) interface{} {
+	return v.(int) % 2
+}
+
+func TestStream_MapWithState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := NewFileStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := Range(0, 5).MapWithState(parity, store, runningSum)
+	for range out {
+	}
+	if v, _, _ := store.Get("0"); v != 6.0 {
+		t.Fatalf("expected even sum 6, got %v", v)
+	}
+
+	store, err = NewFileStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ = Just(5).MapWithState(parity, store, runningSum)
+	if v := <-out; v != 9.0 {
+		t.Fatalf("expected the odd sum to survive a restart, got %v", v)
+	}
+
+	keys := make([]string, 0)
+	_ = store.Range(func(key string, value interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if len(keys) != 2 || keys[0] != "0" || keys[1] != "1" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+}
@@ -69,6 +69,10 @@ func (s Stream) Handle(apply HandleFunc) (Stream, Stream) {
 	go func() {
 		wg.Done()
 		for v := range s {
+			if IsBarrier(v) {
+				out <- v
+				continue
+			}
 			res, err := apply(v)
 			if err != nil {
 				errs <- err
@@ -84,12 +88,17 @@ func (s Stream) Handle(apply HandleFunc) (Stream, Stream) {
 	return Stream(out), Stream(errs)
 }
 
+// Consume calls apply with every item. Barriers are dropped, so a
+// checkpointed pipeline must end in a Checkpointer sink rather than here.
 func (s Stream) Consume(apply ConsumeFunc) Stream {
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		wg.Done()
 		for v := range s {
+			if IsBarrier(v) {
+				continue
+			}
 			apply(v)
 		}
 	}()
@@ -97,9 +106,18 @@ func (s Stream) Consume(apply ConsumeFunc) Stream {
 	return s
 }
 
+// Classify sends every item to the handler of its class, or to deadHandler.
+// Barriers are sent to every handler and to deadHandler.
 func (s Stream) Classify(apply ClassifyFunc, handlers map[string]Stream, deadHandler Stream) {
 	go func() {
 		for v := range s {
+			if IsBarrier(v) {
+				for _, handler := range handlers {
+					handler <- v
+				}
+				deadHandler <- v
+				continue
+			}
 			class := apply(v)
 			if handler, ok := handlers[class]; ok {
 				handler <- v
@@ -114,6 +132,10 @@ func (s Stream) Map(apply MappableFunc) Stream {
 	out := make(chan interface{})
 	go func() {
 		for v := range s {
+			if IsBarrier(v) {
+				out <- v
+				continue
+			}
 			out <- apply(v)
 		}
 		close(out)
@@ -126,6 +148,10 @@ func (s Stream) Take(nth uint) Stream {
 	go func() {
 		takeCount := 0
 		for item := range s {
+			if IsBarrier(item) {
+				out <- item
+				continue
+			}
 			if takeCount < int(nth) {
 				takeCount += 1
 				out <- item
@@ -138,11 +164,17 @@ func (s Stream) Take(nth uint) Stream {
 	return Stream(out)
 }
 
+// TakeLast emits the last nth items once s closes. Barriers are passed on
+// immediately rather than held back with the items.
 func (s Stream) TakeLast(nth uint) Stream {
 	out := make(chan interface{})
 	go func() {
 		buf := make([]interface{}, nth)
 		for item := range s {
+			if IsBarrier(item) {
+				out <- item
+				continue
+			}
 			if len(buf) >= int(nth) {
 				buf = buf[1:]
 			}
@@ -247,6 +279,10 @@ func (s Stream) Scan(apply ScannableFunc) Stream {
 	go func() {
 		var current interface{}
 		for item := range s {
+			if IsBarrier(item) {
+				out <- item
+				continue
+			}
 			out <- apply(current, item)
 			current = apply(current, item)
 		}
@@ -255,11 +291,17 @@ func (s Stream) Scan(apply ScannableFunc) Stream {
 	return Stream(out)
 }
 
+// SkipLast emits every item but the last nth. Barriers are passed on
+// immediately rather than held back with the items.
 func (s Stream) SkipLast(nth uint) Stream {
 	out := make(chan interface{})
 	go func() {
 		buf := make(chan interface{}, nth)
 		for item := range s {
+			if IsBarrier(item) {
+				out <- item
+				continue
+			}
 			select {
 			case buf <- item:
 			default:
@@ -278,6 +320,10 @@ func (s Stream) Skip(nth uint) Stream {
 	go func() {
 		skipCount := 0
 		for item := range s {
+			if IsBarrier(item) {
+				out <- item
+				continue
+			}
 			if skipCount < int(nth) {
 				skipCount += 1
 				continue
@@ -294,6 +340,10 @@ func (s Stream) DistinctUntilChanged(apply KeySelectorFunc) Stream {
 	go func() {
 		var current interface{}
 		for item := range s {
+			if IsBarrier(item) {
+				out <- item
+				continue
+			}
 			key := apply(item)
 			if current != key {
 				out <- item
@@ -310,6 +360,10 @@ func (s Stream) Distinct(apply KeySelectorFunc) Stream {
 	go func() {
 		keysets := make(map[interface{}]struct{})
 		for item := range s {
+			if IsBarrier(item) {
+				out <- item
+				continue
+			}
 			key := apply(item)
 			_, ok := keysets[key]
 			if !ok {
@@ -322,11 +376,16 @@ func (s Stream) Distinct(apply KeySelectorFunc) Stream {
 	return Stream(out)
 }
 
+// First emits the first item and closes. Barriers before it are passed
+// on; the ones after it are not, as the output is closed by then.
 func (s Stream) First() Stream {
 	out := make(chan interface{})
 	go func() {
 		for item := range s {
 			out <- item
+			if IsBarrier(item) {
+				continue
+			}
 			break
 		}
 		close(out)
@@ -338,6 +397,10 @@ func (s Stream) Filter(apply FilterableFunc) Stream {
 	out := make(chan interface{})
 	go func() {
 		for item := range s {
+			if IsBarrier(item) {
+				out <- item
+				continue
+			}
 			if apply(item) {
 				out <- item
 			}
@@ -2,6 +2,7 @@ package stream
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 )
@@ -18,10 +19,39 @@ type Streams interface {
 	Resolve(resolution interface{})
 	Cancel()
 	Close()
+	Shutdown(ctx context.Context) error
 	Then(apply interface{}) Streams
 	Catch(apply ErrorHandleFunc) Streams
+	Use(mws ...Middleware) Streams
 	Pipe(...Streams) Streams
+	PipeRoute(...*Route) Streams
+	Unpipe(name string) Streams
 	Await() (interface{}, error)
+	Submit(item interface{}) *Promise
+	Concurrency(maxInFlight int, ordered bool) Streams
+}
+
+var StreamsClosedError = errors.New("streams no longer takes input")
+
+// Route is a downstream Streams fed by Pipe. Every successful result that
+// passes Filter is sent through Transform to the Target's Input, and every
+// failure is sent to Errors as a Failure when it is set. A route is known
+// by Name, the Target's name by default; a route without Target only
+// carries failures.
+type Route struct {
+	Name      string
+	Target    Streams
+	Filter    FilterableFunc
+	Transform MappableFunc
+	Errors    Stream
+}
+
+// Failure is an item that failed in a Streams chain, with the reason.
+// It is deliberately not an error, so that a Streams fed with failures
+// hands them to its handlers instead of rejecting them.
+type Failure struct {
+	Item interface{}
+	Err  error
 }
 
 type streams struct {
@@ -32,11 +62,41 @@ type streams struct {
 	cancelFunc  context.CancelFunc
 	then        []interface{}
 	catch       []ErrorHandleFunc
-	downStreams map[string]Streams
+	middleware  []Middleware
+	downStreams map[string]*Route
+	registry    *Registry
+	supervisor  *Supervisor
+	loop        func(*streams)
+	done        chan struct{}
+	stop        sync.Once
+	slots       chan struct{}
+	ordered     bool
+	inflight    int
+	idle        *sync.Cond
+	panics      chan *PanicError
+	nextSeq     uint64
+	emitSeq     uint64
+	reorder     map[uint64]*itemRun
+	emitMutex   sync.Mutex
 	result      interface{}
 	err         error
 	mutex       *sync.Mutex
-	wg          sync.WaitGroup
+}
+
+// itemRun is one item going through the chain of a Streams, with the
+// handlers of the Streams when it was admitted and its outcome.
+type itemRun struct {
+	seq           uint64
+	item          interface{}
+	correlationID string
+	then          []interface{}
+	catch         []ErrorHandleFunc
+	middleware    []Middleware
+	ordered       bool
+	slots         chan struct{}
+	result        interface{}
+	err           error
+	panicked      *PanicError
 }
 
 type OnceStreams struct {
@@ -57,23 +117,63 @@ func Once(name string, ctx context.Context, handles ...interface{}) *OnceStreams
 		cancelFunc:  cancelFunc,
 		then:        handles,
 		catch:       make([]ErrorHandleFunc, 0),
-		downStreams: make(map[string]Streams),
+		downStreams: make(map[string]*Route),
+		registry:    RegistryFrom(ctx),
+		supervisor:  SupervisorFrom(ctx),
+		done:        make(chan struct{}),
+		slots:       make(chan struct{}, 1),
+		panics:      make(chan *PanicError, 1),
+		reorder:     make(map[uint64]*itemRun),
 		result:      nil,
 		err:         nil,
 		mutex:       &sync.Mutex{},
 	}
+	s.idle = sync.NewCond(s.mutex)
 	return &OnceStreams{s}
 }
 
 func With(name string, ctx context.Context, buffSize int, handles ...interface{}) Streams {
 	loop := func(s *streams) {
 		for {
+			slots, p := s.acquire()
+			if p != nil {
+				if !s.supervisor.handle(s, p) {
+					return
+				}
+				continue
+			}
+			if slots == nil {
+				s.stopped()
+				return
+			}
 			select {
 			case <-s.ctx.Done():
-				s.Cancel()
-				break
-			case v := <-s.Stream:
-				s.Resolve(v)
+				<-slots
+				s.stopped()
+				return
+			case p := <-s.panics:
+				<-slots
+				if !s.supervisor.handle(s, p) {
+					return
+				}
+			case v, ok := <-s.Stream:
+				if !ok {
+					<-slots
+					s.drained()
+					return
+				}
+				if err := s.ctx.Err(); err != nil {
+					// cancelled while v was waiting, v is not run
+					<-slots
+					if env, ok := v.(Envelope); ok {
+						Reply(env.CorrelationID, nil, err)
+					}
+					s.stopped()
+					return
+				}
+				if p := s.dispatch(v, slots); p != nil && !s.supervisor.handle(s, p) {
+					return
+				}
 			}
 		}
 	}
@@ -92,11 +192,19 @@ func newStreams(name string, ctx context.Context, n int, loop func(*streams), ha
 		cancelFunc:  cancelFunc,
 		then:        handles,
 		catch:       make([]ErrorHandleFunc, 0),
-		downStreams: make(map[string]Streams),
+		downStreams: make(map[string]*Route),
+		registry:    RegistryFrom(ctx),
+		supervisor:  SupervisorFrom(ctx),
+		loop:        loop,
+		done:        make(chan struct{}),
+		slots:       make(chan struct{}, 1),
+		panics:      make(chan *PanicError, 1),
+		reorder:     make(map[uint64]*itemRun),
 		result:      nil,
 		err:         nil,
 		mutex:       &sync.Mutex{},
 	}
+	s.idle = sync.NewCond(s.mutex)
 	go loop(s)
 	return s
 }
@@ -110,144 +218,361 @@ func (s *streams) Input() Stream {
 func (s *streams) Cancel() {
 	s.cancelFunc()
 }
+
+// Resolve runs resolution through the chain in the calling goroutine. A
+// panic goes to the loop of s like the panic of any item, or straight to
+// the supervisor when s has no loop.
 func (s *streams) Resolve(resolution interface{}) {
-	if resolution == nil {
+	p := s.process(resolution)
+	if p == nil {
+		return
+	}
+	if s.loop == nil {
+		s.supervisor.handle(s, p)
 		return
 	}
+	s.report(p)
+}
+
+// process resolves one item and returns the panic it caused, if any.
+func (s *streams) process(resolution interface{}) *PanicError {
+	r := s.admit(resolution)
+	if r == nil {
+		return nil
+	}
+	r.slots <- struct{}{}
+	s.run(r)
+	return r.panicked
+}
+
+// acquire takes a slot for the next item of the input. It returns a panic
+// reported by an item instead, so that no item is read before the panic
+// has been handled, and no slot when s is cancelled.
+func (s *streams) acquire() (chan struct{}, *PanicError) {
+	select {
+	case p := <-s.panics:
+		return nil, p
+	default:
+	}
 	s.mutex.Lock()
-	s.wg = sync.WaitGroup{}
-	s.wg.Add(1)
-	s.resolve(resolution)
-	s.wg.Done()
+	slots := s.slots
 	s.mutex.Unlock()
+	select {
+	case slots <- struct{}{}:
+	case p := <-s.panics:
+		return nil, p
+	case <-s.ctx.Done():
+		return nil, nil
+	}
+	// an item reports its panic before freeing its slot
+	select {
+	case p := <-s.panics:
+		<-slots
+		return nil, p
+	default:
+	}
+	return slots, nil
+}
+
+// dispatch runs an item of the input in the slot taken by acquire. With
+// more than one slot the item runs in its own goroutine, which reports a
+// panic to the loop.
+func (s *streams) dispatch(resolution interface{}, slots chan struct{}) *PanicError {
+	r := s.admit(resolution)
+	if r == nil {
+		<-slots
+		return nil
+	}
+	r.slots = slots
+	if cap(slots) <= 1 {
+		s.run(r)
+		return r.panicked
+	}
+	go func() {
+		defer func() {
+			<-r.slots
+		}()
+		s.complete(r)
+		if r.panicked != nil {
+			s.report(r.panicked)
+		}
+	}()
+	return nil
+}
+
+// report hands the panic of an item run in its own goroutine to the loop.
+// The item keeps its slot meanwhile, so the loop reads no other item before
+// handling the panic. Once the loop is done, the panic is handled here.
+func (s *streams) report(p *PanicError) {
+	select {
+	case s.panics <- p:
+	case <-s.done:
+		s.supervisor.handle(s, p)
+	}
 }
-func (s *streams) resolveResult(result interface{}, err error) bool {
-	s.result = result
-	s.err = err
+
+// admit registers resolution as in flight, or returns nil when s is shut down.
+func (s *streams) admit(resolution interface{}) *itemRun {
+	if resolution == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case <-s.done:
+		return nil
+	default:
+	}
+	r := &itemRun{
+		seq:        s.nextSeq,
+		item:       resolution,
+		then:       append([]interface{}{}, s.then...),
+		catch:      append([]ErrorHandleFunc{}, s.catch...),
+		middleware: append([]Middleware{}, s.middleware...),
+		ordered:    s.ordered,
+		slots:      s.slots,
+	}
+	if env, ok := resolution.(Envelope); ok {
+		r.item, r.correlationID = env.Item, env.CorrelationID
+	}
+	s.nextSeq++
+	s.inflight++
+	return r
+}
+
+// run resolves r, which holds a slot, hands its outcome on and frees the slot.
+func (s *streams) run(r *itemRun) {
+	defer func() {
+		<-r.slots
+	}()
+	s.complete(r)
+}
+
+// complete resolves r and hands its outcome on.
+func (s *streams) complete(r *itemRun) {
+	s.resolve(r)
+	if r.correlationID != "" {
+		Reply(r.correlationID, r.result, r.err)
+	}
+	s.emit(r)
+}
+
+// emit publishes r, after the items admitted before it when it is ordered.
+func (s *streams) emit(r *itemRun) {
+	s.emitMutex.Lock()
+	defer s.emitMutex.Unlock()
+	if r.ordered {
+		s.reorder[r.seq] = r
+	} else {
+		s.publish(r)
+		s.reorder[r.seq] = nil
+	}
+	for {
+		next, ok := s.reorder[s.emitSeq]
+		if !ok {
+			break
+		}
+		delete(s.reorder, s.emitSeq)
+		s.emitSeq++
+		if next != nil {
+			s.publish(next)
+		}
+	}
+}
+
+// publish makes r the last outcome of s and forwards it downstream.
+func (s *streams) publish(r *itemRun) {
+	s.mutex.Lock()
+	s.result, s.err = r.result, r.err
+	routes := make([]*Route, 0, len(s.downStreams))
+	for _, route := range s.downStreams {
+		routes = append(routes, route)
+	}
+	s.mutex.Unlock()
+
+	s.forward(r, routes)
+
+	s.mutex.Lock()
+	s.inflight--
+	if s.inflight == 0 {
+		s.idle.Broadcast()
+	}
+	s.mutex.Unlock()
+}
+
+// drained waits for the items in flight and marks s as finished.
+func (s *streams) drained() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for s.inflight > 0 {
+		s.idle.Wait()
+	}
+	s.stopped()
+}
+
+func (s *streams) resolveResult(r *itemRun, result interface{}, err error) bool {
+	r.result = result
+	r.err = err
 	if err != nil {
-		s.reject(err)
+		s.reject(r, err)
 		return false
 	}
 
-	if s.result == nil {
+	if r.result == nil {
 		return false
 	}
 
-	switch s.result.(type) {
+	switch r.result.(type) {
 	case *Promise:
-		p := s.result.(*Promise)
+		p := r.result.(*Promise)
 		res, err := p.Await()
 		if err != nil {
-			s.reject(err)
+			s.reject(r, err)
 			return false
 		}
-		s.result = res
+		r.result = res
 	case error:
-		err := s.result.(error)
-		s.reject(err)
+		err := r.result.(error)
+		s.reject(r, err)
 		return false
 	}
 	return true
 }
-func (s *streams) resolve(resolution interface{}) {
-	if !s.resolveResult(resolution, nil) {
+func (s *streams) resolve(r *itemRun) {
+	if !s.resolveResult(r, r.item, nil) {
 		return
 	}
-loop:
-	for _, apply := range s.then {
-		switch apply.(type) {
-		case *Handler:
-			fn := apply.(*Handler)
-			var v interface{}
-			if fn.Before != nil {
-				v = fn.Before
-			} else {
-				v = s.ctx.Value(NodeFuncKey(BeforeHandlePrefix, fn))
-			}
-			if v != nil {
-				before := v.(HandleFunc)
-				if !s.resolveResult(before(s.result)) {
-					break loop
-				}
-			}
-			r, err := fn.Eval(s.result)
-			if !s.resolveResult(r, err) {
-				break loop
-			}
+	for _, apply := range r.then {
+		step, pipes, err := s.step(apply, r.middleware)
+		if err != nil {
+			s.resolveResult(r, nil, err)
+			return
+		}
+		if step == nil {
+			continue
+		}
+		result, err := s.call(r, step, r.result)
+		if !s.resolveResult(r, result, err) {
+			return
+		}
+		for _, p := range pipes {
+			p <- r.result
+		}
+	}
+}
 
-			if fn.After != nil {
-				v = fn.After
-			} else {
-				v = s.ctx.Value(NodeFuncKey(AfterHandlePrefix, fn))
-			}
-			if v != nil {
-				after := v.(HandleFunc)
-				if !s.resolveResult(after(s.result)) {
-					break loop
-				}
-			}
+// call runs step, turning a panic into a PanicError failure of the item.
+func (s *streams) call(r *itemRun, step HandleFunc, item interface{}) (result interface{}, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			r.panicked = NewPanicError(v, item)
+			result, err = nil, r.panicked
+		}
+	}()
+	return step(item)
+}
 
-			if fn.Pipes != nil {
-				for _, p := range fn.Pipes {
-					p <- s.result
-				}
-			}
-		case SliceHandler:
-			fn := apply.(SliceHandler)
-			var v interface{}
-			if fn.Before != nil {
-				v = fn.Before
-			} else {
-				v = s.ctx.Value(NodeFuncKey(BeforeHandlePrefix, fn))
-			}
-			if v != nil {
-				before := v.(HandleFunc)
-				if !s.resolveResult(before(s.result)) {
-					break loop
-				}
-			}
-			if !s.resolveResult(fn.Eval(s.result)) {
-				break loop
-			}
+// settle waits for a *Promise result and turns an error result into a
+// failure, the way resolveResult does between the steps of the chain.
+func settle(result interface{}, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	switch r := result.(type) {
+	case *Promise:
+		return r.Await()
+	case error:
+		return nil, r
+	}
+	return result, nil
+}
 
-			if fn.After != nil {
-				v = fn.After
-			} else {
-				v = s.ctx.Value(NodeFuncKey(AfterHandlePrefix, fn))
-			}
-			if v != nil {
-				after := v.(HandleFunc)
-				if !s.resolveResult(after(s.result)) {
-					break loop
-				}
-			}
+// step builds the HandleFunc running one element of the chain for an item:
+// its Before hook, the handler and its After hook, wrapped in the global,
+// Streams and handler middleware, outermost first. A nil or failed result
+// of a hook ends the step. It also returns the pipes fed with the result.
+func (s *streams) step(apply interface{}, middleware []Middleware) (HandleFunc, []Stream, error) {
+	name, ok := apply.(string)
+	if ok {
+		if apply, ok = s.registry.Handler(name); !ok {
+			return nil, nil, fmt.Errorf("%w %q", UnknownHandlerError, name)
+		}
+	} else {
+		name = HandlerName(apply)
+	}
+	var (
+		fn            HandleFunc
+		before, after HandleFunc
+		pipes         []Stream
+		mws           []Middleware
+	)
+	switch h := apply.(type) {
+	case *Handler:
+		fn, before, after, pipes, mws = h.Eval, h.Before, h.After, h.Pipes, h.Middleware
+	case *SliceHandler:
+		fn, before, after, pipes, mws = h.Eval, h.Before, h.After, h.Pipes, h.Middleware
+	case SliceHandler:
+		fn, before, after, pipes, mws = h.Eval, h.Before, h.After, h.Pipes, h.Middleware
+	case HandleFunc:
+		fn = h
+	case func(interface{}) (interface{}, error):
+		fn = h
+	default:
+		return nil, nil, nil
+	}
+	before = s.hook(BeforeHandlePrefix, name, apply, before)
+	after = s.hook(AfterHandlePrefix, name, apply, after)
 
-			if fn.Pipes != nil {
-				for _, p := range fn.Pipes {
-					p <- s.result
+	run := fn
+	if before != nil || after != nil {
+		run = func(v interface{}) (interface{}, error) {
+			if before != nil {
+				r, err := settle(before(v))
+				if err != nil || r == nil {
+					return r, err
 				}
+				v = r
 			}
-		case HandleFunc:
-		case func(interface{}) (interface{}, error):
-			fn := apply.(func(interface{}) (interface{}, error))
-			if v := s.ctx.Value(NodeFuncKey(BeforeHandlePrefix, fn)); v != nil {
-				before := v.(func(interface{}) (interface{}, error))
-				if !s.resolveResult(before(s.result)) {
-					break loop
-				}
-			}
-			if !s.resolveResult(fn(s.result)) {
-				break loop
-			}
-			if v := s.ctx.Value(NodeFuncKey(AfterHandlePrefix, fn)); v != nil {
-				after := v.(func(interface{}) (interface{}, error))
-				if !s.resolveResult(after(s.result)) {
-					break loop
-				}
+			r, err := settle(fn(v))
+			if after == nil || err != nil || r == nil {
+				return r, err
 			}
+			return after(r)
 		}
+	}
+	chain := append(s.registry.Global(), middleware...)
+	chain = append(chain, mws...)
+	return Chain(chain...)(run), pipes, nil
+}
 
+// hook returns the Before or After hook of fn: its own when set, otherwise
+// the one registered under name, which is the name fn was looked up by in
+// the registry or else its HandlerName, otherwise one stored in the context
+// under NodeFuncKey.
+func (s *streams) hook(prefix string, name string, fn interface{}, own HandleFunc) HandleFunc {
+	if own != nil {
+		return own
 	}
+	var (
+		hook HandleFunc
+		ok   bool
+	)
+	switch prefix {
+	case BeforeHandlePrefix:
+		hook, ok = s.registry.Before(name)
+	case AfterHandlePrefix:
+		hook, ok = s.registry.After(name)
+	}
+	if ok {
+		return hook
+	}
+	switch v := s.ctx.Value(NodeFuncKey(prefix, fn)).(type) {
+	case HandleFunc:
+		return v
+	case func(interface{}) (interface{}, error):
+		return v
+	}
+	return nil
 }
 
 func NodeFuncKey(prefix string, fn interface{}) string {
@@ -273,19 +598,21 @@ func HandleFuncKey(prefix string, fn HandleFunc) string {
 	return fmt.Sprintf("%s-%s-%s", prefix, pkg, name)
 }
 
-func (s *streams) reject(err error) {
+func (s *streams) reject(r *itemRun, err error) {
 	if err != nil {
-		s.err = err
+		r.err = err
 	}
-	for _, fn := range s.catch {
-		err = fn(s.err)
+	for _, fn := range r.catch {
+		err = fn(r.err)
 		if err != nil {
-			s.err = err
+			r.err = err
 		}
 	}
-	if v := s.ctx.Value(OnErrorHandle); v != nil {
+	if onError, ok := s.registry.OnError(s.name); ok {
+		_ = onError(r.err)
+	} else if v := s.ctx.Value(OnErrorHandle); v != nil {
 		if onErrorHandle, ok := v.(ErrorHandleFunc); ok {
-			_ = onErrorHandle(s.err)
+			_ = onErrorHandle(r.err)
 		}
 	}
 }
@@ -293,6 +620,61 @@ func (s *streams) Close() {
 	s.Stream.Close()
 	s.Cancel()
 }
+
+// stopped marks the loop of s as finished for good.
+func (s *streams) stopped() {
+	s.stop.Do(func() {
+		close(s.done)
+	})
+	s.abandon()
+}
+
+// abandon replies to the requests still buffered in the input of s, which
+// its loop will not read any more, with ctx.Err() or StreamsClosedError.
+func (s *streams) abandon() {
+	if s.Stream == nil {
+		return
+	}
+	err := s.ctx.Err()
+	if err == nil {
+		err = StreamsClosedError
+	}
+	for {
+		select {
+		case v, ok := <-s.Stream:
+			if !ok {
+				return
+			}
+			if env, ok := v.(Envelope); ok {
+				Reply(env.CorrelationID, nil, err)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Shutdown closes the input of s, lets the items already buffered run
+// through the handler chain and waits for the item in flight, which hands
+// its results to the downstream Streams. It then cancels s. If ctx ends
+// first, s is cancelled right away and ctx.Err() is returned. Downstream
+// Streams are left running: a Network shuts its nodes down in order.
+func (s *streams) Shutdown(ctx context.Context) error {
+	if s.Stream == nil {
+		// no loop, wait for the items resolving
+		go s.drained()
+	} else {
+		s.Stream.Close()
+	}
+	select {
+	case <-s.done:
+		s.Cancel()
+		return nil
+	case <-ctx.Done():
+		s.Cancel()
+		return fmt.Errorf("shutting down %s: %w", s.name, ctx.Err())
+	}
+}
 func (s *streams) Then(apply interface{}) Streams {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -303,6 +685,13 @@ func (s *streams) Then(apply interface{}) Streams {
 		s.then = append(s.then, apply)
 	case func(interface{}) (interface{}, error):
 		s.then = append(s.then, apply)
+	case *SliceHandler:
+		s.then = append(s.then, apply)
+	case SliceHandler:
+		s.then = append(s.then, apply)
+	case string:
+		// resolved from the registry when an item arrives
+		s.then = append(s.then, apply)
 	default:
 		return s
 	}
@@ -314,16 +703,147 @@ func (s *streams) Catch(apply ErrorHandleFunc) Streams {
 	s.catch = append(s.catch, apply)
 	return s
 }
-func (s *streams) Pipe(streams ...Streams) Streams {
+
+// Use adds middleware applied to every handler of s, inside the global
+// middleware and outside the handler's own.
+func (s *streams) Use(mws ...Middleware) Streams {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	s.middleware = append(s.middleware, mws...)
+	return s
+}
+func (s *streams) Pipe(streams ...Streams) Streams {
+	routes := make([]*Route, 0, len(streams))
 	for _, strm := range streams {
-		s.downStreams[strm.StreamName()] = strm
+		routes = append(routes, &Route{Target: strm})
+	}
+	return s.PipeRoute(routes...)
+}
+func (s *streams) PipeRoute(routes ...*Route) Streams {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, route := range routes {
+		if route == nil {
+			continue
+		}
+		name := route.Name
+		if name == "" && route.Target != nil {
+			name = route.Target.StreamName()
+		}
+		if name == "" {
+			continue
+		}
+		s.downStreams[name] = route
 	}
 	return s
 }
+func (s *streams) Unpipe(name string) Streams {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.downStreams, name)
+	return s
+}
+
+// forward hands the outcome of r to the downstream routes.
+func (s *streams) forward(r *itemRun, routes []*Route) {
+	for _, route := range routes {
+		if r.err != nil {
+			if route.Errors != nil {
+				s.send(route.Errors, Failure{Item: r.item, Err: r.err})
+			}
+			continue
+		}
+		if r.result == nil || route.Target == nil {
+			continue
+		}
+		if route.Filter != nil && !route.Filter(r.result) {
+			continue
+		}
+		v := r.result
+		if route.Transform != nil {
+			v = route.Transform(v)
+		}
+		if in := route.Target.Input(); in != nil {
+			s.send(in, v)
+		} else {
+			route.Target.Resolve(v)
+		}
+	}
+}
+
+// send reports whether v was sent before to was closed or s cancelled.
+func (s *streams) send(to Stream, v interface{}) bool {
+	return sendTo(s.ctx, to, v) == nil
+}
+
+// sendTo sends v to the input of a Streams, failing with StreamsClosedError
+// when the input is closed, or with ctx.Err() when ctx ends first.
+func sendTo(ctx context.Context, to Stream, v interface{}) (err error) {
+	defer func() {
+		// the input may have been closed meanwhile
+		if recover() != nil {
+			err = StreamsClosedError
+		}
+	}()
+	select {
+	case to <- v:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
+// Await waits for the items in flight, if any, and returns the result of
+// the last item published. Use Submit to get the result of a given item.
 func (s *streams) Await() (interface{}, error) {
-	s.wg.Wait()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for s.inflight > 0 {
+		s.idle.Wait()
+	}
 	return s.result, s.err
 }
+
+// Concurrency lets up to maxInFlight items run through the chain at once,
+// one at a time by default, so that handlers waiting on I/O or returning
+// a *Promise overlap. With ordered, results are handed downstream in the
+// order the items were admitted; otherwise as soon as they are ready. It
+// applies to the items admitted from now on.
+func (s *streams) Concurrency(maxInFlight int, ordered bool) Streams {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if maxInFlight < 1 {
+		maxInFlight = 1
+	}
+	s.slots = make(chan struct{}, maxInFlight)
+	s.ordered = ordered
+	return s
+}
+
+// Submit hands item to s and returns a promise settled with its own final
+// result, once the Then and Catch handlers have run. A Streams with an
+// input is sent the item, waiting for room in its buffer; a Once Streams
+// resolves it right away. The promise is rejected with StreamsClosedError
+// if s no longer takes input, or with the error of the context of s when s
+// is cancelled or stopped by its supervisor before the item runs.
+func (s *streams) Submit(item interface{}) *Promise {
+	id, promise := expectReply(func() {})
+	env := Envelope{CorrelationID: id, Item: item}
+	if s.Stream == nil {
+		s.Resolve(env)
+		// a Streams shut down ignores the item
+		Reply(id, nil, StreamsClosedError)
+		return promise
+	}
+	if !s.send(s.Stream, env) {
+		Reply(id, nil, StreamsClosedError)
+		return promise
+	}
+	select {
+	case <-s.done:
+		// the loop stopped meanwhile and may have missed env
+		s.abandon()
+	default:
+	}
+	return promise
+}
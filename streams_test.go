@@ -2,6 +2,7 @@ package stream
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"testing"
@@ -86,3 +87,182 @@ func f(vs ...interface{}) interface{} {
 	}
 	return nil
 }
+
+func TestStreams_Pipe(t *testing.T) {
+	ctx := context.Background()
+	results := New(10)
+	errs := New(10)
+	collect := With("collect", ctx, 10, func(v interface{}) (interface{}, error) {
+		results <- v
+		return v, nil
+	})
+	double := func(v interface{}) interface{} {
+		return v.(int) * 2
+	}
+	even := func(v interface{}) bool {
+		return v.(int)%2 == 0
+	}
+	s := Once("source", ctx, func(v interface{}) (interface{}, error) {
+		if v.(int) < 0 {
+			return nil, fmt.Errorf("negative: %v", v)
+		}
+		return v, nil
+	})
+	s.PipeRoute(&Route{Target: collect, Filter: even, Transform: double, Errors: errs})
+
+	for _, v := range []int{1, 2, -1, 4} {
+		s.Resolve(v)
+	}
+	if v := <-results; v != 4 {
+		t.Fatalf("expected 4, got %v", v)
+	}
+	if v := <-results; v != 8 {
+		t.Fatalf("expected 8, got %v", v)
+	}
+	if f := (<-errs).(Failure); f.Item != -1 || f.Err.Error() != "negative: -1" {
+		t.Fatalf("unexpected failure %v", f)
+	}
+
+	s.Unpipe("collect")
+	s.Resolve(6)
+	select {
+	case v := <-results:
+		t.Fatalf("expected nothing after Unpipe, got %v", v)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestStreams_Submit(t *testing.T) {
+	s := With("submit", context.Background(), 10, func(v interface{}) (interface{}, error) {
+		if v.(int)%3 == 0 {
+			return nil, fmt.Errorf("multiple of 3: %v", v)
+		}
+		return v.(int) * 2, nil
+	})
+	s.Catch(func(err error) error {
+		return fmt.Errorf("caught: %w", err)
+	})
+	promises := make([]*Promise, 10)
+	for i := range promises {
+		promises[i] = s.Submit(i + 1)
+	}
+	for i, p := range promises {
+		v, err := p.Await()
+		if (i+1)%3 == 0 {
+			if err == nil || err.Error() != fmt.Sprintf("caught: multiple of 3: %d", i+1) {
+				t.Fatalf("expected item %d to be caught, got %v", i+1, err)
+			}
+			continue
+		}
+		if err != nil || v != (i+1)*2 {
+			t.Fatalf("expected %d, got %v, %v", (i+1)*2, v, err)
+		}
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Submit(1).Await(); err != StreamsClosedError {
+		t.Fatalf("expected StreamsClosedError, got %v", err)
+	}
+
+	once := Once("once", context.Background(), func(v interface{}) (interface{}, error) {
+		return v.(int) + 1, nil
+	})
+	if v, err := once.Submit(1).Await(); err != nil || v != 2 {
+		t.Fatalf("expected 2, got %v, %v", v, err)
+	}
+}
+
+func TestStreams_SubmitStopped(t *testing.T) {
+	settled := func(p *Promise) error {
+		select {
+		case <-p.Done():
+		case <-time.After(time.Second):
+			t.Fatal("promise of a buffered item never settled")
+		}
+		_, err := p.Await()
+		return err
+	}
+	before := PendingRequests()
+
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	s := With("submit-cancel", context.Background(), 10, func(v interface{}) (interface{}, error) {
+		started <- struct{}{}
+		<-release
+		return v, nil
+	})
+	running := s.Submit(1)
+	<-started
+	buffered := []*Promise{s.Submit(2), s.Submit(3)}
+	s.Cancel()
+	close(release)
+	if err := settled(running); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range buffered {
+		if err := settled(p); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	}
+
+	sv := NewSupervisor(context.Background(), Stop, 0)
+	release = make(chan struct{})
+	s = With("submit-stop", sv.Context(), 10, func(v interface{}) (interface{}, error) {
+		started <- struct{}{}
+		<-release
+		panic(v)
+	})
+	running = s.Submit(1)
+	<-started
+	p := s.Submit(2)
+	close(release)
+	if err := settled(running); !errors.Is(err, RecoveredPanicError) {
+		t.Fatalf("expected a PanicError, got %v", err)
+	}
+	if err := settled(p); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled after the supervisor stopped, got %v", err)
+	}
+	if n := PendingRequests(); n != before {
+		t.Fatalf("expected no pending request left, got %d", n-before)
+	}
+}
+
+func TestStreams_Concurrency(t *testing.T) {
+	// later items finish first
+	slow := func(v interface{}) (interface{}, error) {
+		return NewPromise(func(resolve func(interface{}), reject func(error)) {
+			time.Sleep(time.Duration(5-v.(int)) * 20 * time.Millisecond)
+			resolve(v)
+		}), nil
+	}
+	for _, ordered := range []bool{true, false} {
+		results := New(10)
+		collect := With("collect", context.Background(), 10, func(v interface{}) (interface{}, error) {
+			results <- v
+			return v, nil
+		})
+		s := With("concurrent", context.Background(), 10, slow).Concurrency(5, ordered).Pipe(collect)
+		start := time.Now()
+		for i := 0; i < 5; i++ {
+			s.Input() <- i
+		}
+		got := make([]interface{}, 0, 5)
+		for i := 0; i < 5; i++ {
+			got = append(got, <-results)
+		}
+		if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+			t.Fatalf("expected the items to overlap, took %v", elapsed)
+		}
+		expected := []interface{}{4, 3, 2, 1, 0}
+		if ordered {
+			expected = []interface{}{0, 1, 2, 3, 4}
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("ordered %v: expected %v, got %v", ordered, expected, got)
+		}
+		if err := s.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		collect.Close()
+	}
+}
@@ -0,0 +1,219 @@
+package stream
+
+import (
+	"sync"
+	"time"
+)
+
+type replayItem struct {
+	value interface{}
+	time  time.Time
+}
+
+type subjectSubscriber struct {
+	*subscriber
+	errs Stream
+}
+
+func (sub *subjectSubscriber) close() {
+	sub.subscriber.close()
+	sub.errs.Close()
+}
+
+// Subject is both an input and a multicast output: values pushed with Next
+// are sent to every subscriber. A replay subject first hands a new
+// subscriber the values it has kept, a behavior subject the current value.
+// Error and Complete end the subject; subscribers joining afterwards still
+// get the replay, then the error, then closed Streams.
+type Subject struct {
+	subscribers map[Stream]*subjectSubscriber
+	size        int
+	window      time.Duration
+	buffer      []replayItem
+	behavior    bool
+	current     interface{}
+	err         error
+	completed   bool
+	sending     sync.Mutex
+	mutex       sync.Mutex
+}
+
+// NewReplaySubject keeps the last n values, or every value when n <= 0,
+// dropping those older than window unless window <= 0.
+func NewReplaySubject(n int, window time.Duration) *Subject {
+	return &Subject{
+		subscribers: make(map[Stream]*subjectSubscriber),
+		size:        n,
+		window:      window,
+		buffer:      make([]replayItem, 0),
+	}
+}
+
+// NewBehaviorSubject starts with initial as its current value.
+func NewBehaviorSubject(initial interface{}) *Subject {
+	return &Subject{
+		subscribers: make(map[Stream]*subjectSubscriber),
+		behavior:    true,
+		current:     initial,
+	}
+}
+
+// Value returns the current value of a behavior subject, or the latest
+// value of a replay subject.
+func (s *Subject) Value() interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.behavior {
+		return s.current
+	}
+	s.prune()
+	if len(s.buffer) == 0 {
+		return nil
+	}
+	return s.buffer[len(s.buffer)-1].value
+}
+
+func (s *Subject) prune() {
+	if s.window > 0 {
+		deadline := time.Now().Add(-s.window)
+		i := 0
+		for i < len(s.buffer) && s.buffer[i].time.Before(deadline) {
+			i++
+		}
+		s.buffer = s.buffer[i:]
+	}
+	if s.size > 0 && len(s.buffer) > s.size {
+		s.buffer = s.buffer[len(s.buffer)-s.size:]
+	}
+}
+
+func (s *Subject) replay() []interface{} {
+	if s.behavior {
+		if s.completed || s.err != nil {
+			return nil
+		}
+		return []interface{}{s.current}
+	}
+	s.prune()
+	values := make([]interface{}, len(s.buffer))
+	for i, item := range s.buffer {
+		values[i] = item.value
+	}
+	return values
+}
+
+// Subscribe returns the value and error Streams of a new subscriber. The
+// value Stream is buffered with room for the replay plus buffer items;
+// policy applies to live values only.
+func (s *Subject) Subscribe(buffer int, policy SlowPolicy) (Stream, Stream) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	values := s.replay()
+	sub := &subjectSubscriber{
+		subscriber: newSubscriber(len(values)+buffer, policy),
+		errs:       New(1),
+	}
+	for _, v := range values {
+		sub.out <- v
+	}
+	switch {
+	case s.err != nil:
+		sub.errs <- s.err
+		sub.close()
+	case s.completed:
+		sub.close()
+	default:
+		s.subscribers[sub.out] = sub
+	}
+	return sub.out, sub.errs
+}
+
+// Unsubscribe closes the Streams of the subscriber owning values.
+func (s *Subject) Unsubscribe(values Stream) {
+	s.mutex.Lock()
+	sub, ok := s.subscribers[values]
+	delete(s.subscribers, values)
+	s.mutex.Unlock()
+	if ok {
+		sub.close()
+	}
+}
+
+// Next sends v to every subscriber and keeps it for later ones. Values are
+// sent outside the subject's lock, so a Block subscriber holding Next back
+// can still be unsubscribed; concurrent calls to Next are sent one at a time.
+func (s *Subject) Next(v interface{}) {
+	s.sending.Lock()
+	defer s.sending.Unlock()
+	s.mutex.Lock()
+	if s.completed || s.err != nil {
+		s.mutex.Unlock()
+		return
+	}
+	if s.behavior {
+		s.current = v
+	} else {
+		s.buffer = append(s.buffer, replayItem{value: v, time: time.Now()})
+		s.prune()
+	}
+	subs := make([]*subjectSubscriber, 0, len(s.subscribers))
+	for _, sub := range s.subscribers {
+		subs = append(subs, sub)
+	}
+	s.mutex.Unlock()
+	for _, sub := range subs {
+		if sub.send(v) != disconnected {
+			continue
+		}
+		s.mutex.Lock()
+		if s.subscribers[sub.out] == sub {
+			delete(s.subscribers, sub.out)
+			sub.errs.Close()
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// Error sends err to every subscriber's error Stream and ends the subject.
+func (s *Subject) Error(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.completed || s.err != nil {
+		return
+	}
+	s.err = err
+	for key, sub := range s.subscribers {
+		sub.errs <- err
+		sub.close()
+		delete(s.subscribers, key)
+	}
+}
+
+// Complete closes every subscriber's Streams and ends the subject.
+func (s *Subject) Complete() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.completed || s.err != nil {
+		return
+	}
+	s.completed = true
+	for key, sub := range s.subscribers {
+		sub.close()
+		delete(s.subscribers, key)
+	}
+}
+
+// Pipe feeds s into the subject until s closes, then completes the subject.
+// Errors found in s are sent with Error.
+func (s *Subject) Pipe(source Stream) {
+	go func() {
+		for v := range source {
+			if err, ok := v.(error); ok {
+				s.Error(err)
+				return
+			}
+			s.Next(v)
+		}
+		s.Complete()
+	}()
+}
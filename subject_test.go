@@ -0,0 +1,124 @@
+package stream
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSubject_UnsubscribeBlocked(t *testing.T) {
+	s := NewReplaySubject(0, 0)
+	values, _ := s.Subscribe(0, Block)
+	go s.Next(1)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			s.mutex.Lock()
+			blocked := len(s.buffer) > 0
+			s.mutex.Unlock()
+			if blocked {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		s.Unsubscribe(values)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Unsubscribe deadlocked with Next")
+	}
+	for range values {
+	}
+}
+
+func collectAll(s Stream) []interface{} {
+	items := make([]interface{}, 0)
+	for v := range s {
+		items = append(items, v)
+	}
+	return items
+}
+
+func TestReplaySubject(t *testing.T) {
+	s := NewReplaySubject(2, 0)
+	early, _ := s.Subscribe(5, Block)
+	for i := 1; i <= 3; i++ {
+		s.Next(i)
+	}
+	late, _ := s.Subscribe(5, Block)
+	s.Complete()
+	if items := collectAll(early); !reflect.DeepEqual(items, []interface{}{1, 2, 3}) {
+		t.Fatalf("expected the early subscriber to see every value, got %v", items)
+	}
+	if items := collectAll(late); !reflect.DeepEqual(items, []interface{}{2, 3}) {
+		t.Fatalf("expected the late subscriber to get the last 2 values, got %v", items)
+	}
+	if v := s.Value(); v != 3 {
+		t.Fatalf("expected the latest value 3, got %v", v)
+	}
+}
+
+func TestReplaySubject_Window(t *testing.T) {
+	s := NewReplaySubject(0, 50*time.Millisecond)
+	s.Next(1)
+	time.Sleep(100 * time.Millisecond)
+	s.Next(2)
+	values, _ := s.Subscribe(5, Block)
+	s.Complete()
+	if items := collectAll(values); !reflect.DeepEqual(items, []interface{}{2}) {
+		t.Fatalf("expected values older than the window to be pruned, got %v", items)
+	}
+}
+
+func TestBehaviorSubject(t *testing.T) {
+	s := NewBehaviorSubject(0)
+	first, _ := s.Subscribe(5, Block)
+	s.Next(1)
+	s.Next(2)
+	second, _ := s.Subscribe(5, Block)
+	s.Next(3)
+	s.Complete()
+	if items := collectAll(first); !reflect.DeepEqual(items, []interface{}{0, 1, 2, 3}) {
+		t.Fatalf("unexpected values for the first subscriber %v", items)
+	}
+	if items := collectAll(second); !reflect.DeepEqual(items, []interface{}{2, 3}) {
+		t.Fatalf("expected the second subscriber to start at the current value, got %v", items)
+	}
+	if v := s.Value(); v != 3 {
+		t.Fatalf("expected the current value 3, got %v", v)
+	}
+	values, errs := s.Subscribe(5, Block)
+	if items := collectAll(values); len(items) != 0 {
+		t.Fatalf("expected no value after Complete, got %v", items)
+	}
+	if items := collectAll(errs); len(items) != 0 {
+		t.Fatalf("expected no error after Complete, got %v", items)
+	}
+}
+
+func TestSubject_ErrorLateSubscriber(t *testing.T) {
+	s := NewReplaySubject(0, 0)
+	early, earlyErrs := s.Subscribe(5, Block)
+	s.Next(1)
+	failure := errors.New("failed")
+	s.Error(failure)
+	s.Next(2)
+
+	late, lateErrs := s.Subscribe(5, Block)
+	if items := collectAll(late); !reflect.DeepEqual(items, []interface{}{1}) {
+		t.Fatalf("expected the late subscriber to get the replay, got %v", items)
+	}
+	if items := collectAll(lateErrs); !reflect.DeepEqual(items, []interface{}{failure}) {
+		t.Fatalf("expected the late subscriber to get the error, got %v", items)
+	}
+	if items := collectAll(early); !reflect.DeepEqual(items, []interface{}{1}) {
+		t.Fatalf("unexpected values for the early subscriber %v", items)
+	}
+	if items := collectAll(earlyErrs); !reflect.DeepEqual(items, []interface{}{failure}) {
+		t.Fatalf("expected the early subscriber to get the error, got %v", items)
+	}
+}
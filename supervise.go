@@ -0,0 +1,246 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// PanicError is a panic recovered while handling Item.
+type PanicError struct {
+	Value interface{}
+	Item  interface{}
+	Stack []byte
+}
+
+// NewPanicError records the panic value r raised while handling item, with
+// the stack of the panicking goroutine. Call it from the deferred recover.
+func NewPanicError(r interface{}, item interface{}) *PanicError {
+	return &PanicError{Value: r, Item: item, Stack: debug.Stack()}
+}
+
+func (e *PanicError) Error() string {
+	if e.Item == nil {
+		return fmt.Sprintf("panic: %v", e.Value)
+	}
+	return fmt.Sprintf("panic: %v (item %v)", e.Value, e.Item)
+}
+
+// Unwrap returns the panic value when it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Is makes every PanicError match RecoveredPanicError.
+func (e *PanicError) Is(target error) bool {
+	return target == RecoveredPanicError
+}
+
+// RestartPolicy tells what happens to an operator or a Streams loop after a
+// panic. The panicking item is failed with a PanicError in every case.
+type RestartPolicy int
+
+const (
+	// Resume goes on with the next item, keeping the operator's state.
+	Resume RestartPolicy = iota
+	// Restart starts the operator or loop again after a backoff.
+	Restart
+	// Stop stops the operator, or every Streams of the supervisor.
+	Stop
+)
+
+func (p RestartPolicy) String() string {
+	switch p {
+	case Resume:
+		return "resume"
+	case Restart:
+		return "restart"
+	case Stop:
+		return "stop"
+	}
+	return fmt.Sprintf("RestartPolicy(%d)", int(p))
+}
+
+// Supervisor applies a RestartPolicy to the loops of the Streams created
+// with its Context, or a context derived from it, such as the nodes of a
+// Graph built with it. Restarts wait for the backoff, doubled after each
+// restart of the same Streams up to the maximum backoff. A Streams
+// restarted more than the maximum number of times stops the supervisor,
+// which cancels its context and with it every supervised Streams.
+type Supervisor struct {
+	policy      RestartPolicy
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	maxRestarts int
+	onPanic     func(name string, err *PanicError)
+	restarts    map[string]int
+	ctx         context.Context
+	cancelFunc  context.CancelFunc
+	mutex       sync.Mutex
+}
+
+type supervisorKey struct{}
+
+func NewSupervisor(ctx context.Context, policy RestartPolicy, backoff time.Duration) *Supervisor {
+	sv := &Supervisor{
+		policy:     policy,
+		backoff:    backoff,
+		maxBackoff: backoff * 32,
+		restarts:   make(map[string]int),
+	}
+	ctx, sv.cancelFunc = context.WithCancel(ctx)
+	sv.ctx = context.WithValue(ctx, supervisorKey{}, sv)
+	return sv
+}
+
+// SupervisorFrom returns the Supervisor carried by ctx, or nil.
+func SupervisorFrom(ctx context.Context) *Supervisor {
+	if ctx == nil {
+		return nil
+	}
+	sv, _ := ctx.Value(supervisorKey{}).(*Supervisor)
+	return sv
+}
+
+// SetMaxBackoff caps the doubling backoff between restarts.
+func (sv *Supervisor) SetMaxBackoff(d time.Duration) *Supervisor {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+	sv.maxBackoff = d
+	return sv
+}
+
+// SetMaxRestarts sets how often one Streams may restart, unlimited by default.
+func (sv *Supervisor) SetMaxRestarts(n int) *Supervisor {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+	sv.maxRestarts = n
+	return sv
+}
+
+// OnPanic sets a func told about every panic of a supervised Streams.
+func (sv *Supervisor) OnPanic(fn func(name string, err *PanicError)) *Supervisor {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+	sv.onPanic = fn
+	return sv
+}
+
+// Context returns the context to create supervised Streams with.
+func (sv *Supervisor) Context() context.Context {
+	return sv.ctx
+}
+
+// Done is closed once the supervisor has stopped.
+func (sv *Supervisor) Done() <-chan struct{} {
+	return sv.ctx.Done()
+}
+
+// Stop cancels every supervised Streams.
+func (sv *Supervisor) Stop() {
+	sv.cancelFunc()
+}
+
+// Restarts returns how often the named Streams has been restarted.
+func (sv *Supervisor) Restarts(name string) int {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+	return sv.restarts[name]
+}
+
+// handle is called by a Streams loop after a panic, and reports whether the
+// loop goes on. A nil Supervisor resumes. A Streams made by Once is handled
+// the same way, except that Restart resumes it.
+func (sv *Supervisor) handle(s *streams, p *PanicError) bool {
+	if sv == nil {
+		return true
+	}
+	sv.mutex.Lock()
+	onPanic := sv.onPanic
+	policy := sv.policy
+	var delay time.Duration
+	if policy == Restart {
+		n := sv.restarts[s.name]
+		if sv.maxRestarts > 0 && n >= sv.maxRestarts {
+			policy = Stop
+		} else {
+			sv.restarts[s.name] = n + 1
+			delay = sv.backoff << uint(n)
+			if delay > sv.maxBackoff || delay <= 0 {
+				delay = sv.maxBackoff
+			}
+		}
+	}
+	sv.mutex.Unlock()
+
+	if onPanic != nil {
+		onPanic(s.name, p)
+	}
+	switch policy {
+	case Restart:
+		if s.loop == nil {
+			// a Once has no loop to restart, it takes the next item as is
+			return true
+		}
+		go func() {
+			select {
+			case <-time.After(delay):
+				s.loop(s)
+			case <-s.ctx.Done():
+				s.stopped()
+			}
+		}()
+		return false
+	case Stop:
+		sv.Stop()
+		s.stopped()
+		return false
+	}
+	return true
+}
+
+// SupervisedMap is Map recovering from panics of the func made by newApply.
+// The item that panicked is sent to the returned errs as a *PanicError.
+// Depending on policy, the next items go to the same func, to a new one
+// made by newApply, or nowhere: out and errs are then closed and s is left
+// to the caller. errs must be drained.
+func (s Stream) SupervisedMap(newApply func() MappableFunc, policy RestartPolicy) (Stream, Stream) {
+	out := make(chan interface{})
+	errs := make(chan interface{})
+	go func() {
+		defer close(out)
+		defer close(errs)
+		apply := newApply()
+		for v := range s {
+			if IsBarrier(v) {
+				out <- v
+				continue
+			}
+			r, p := safeMap(apply, v)
+			if p == nil {
+				out <- r
+				continue
+			}
+			errs <- p
+			switch policy {
+			case Restart:
+				apply = newApply()
+			case Stop:
+				return
+			}
+		}
+	}()
+	return Stream(out), Stream(errs)
+}
+
+func safeMap(apply MappableFunc, v interface{}) (result interface{}, p *PanicError) {
+	defer func() {
+		if r := recover(); r != nil {
+			p = NewPanicError(r, v)
+		}
+	}()
+	return apply(v), nil
+}
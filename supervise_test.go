@@ -0,0 +1,170 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStreams_Panic(t *testing.T) {
+	s := Once("panic", context.Background(), func(v interface{}) (interface{}, error) {
+		return 10 / v.(int), nil
+	})
+	s.Resolve(0)
+	_, err := s.Await()
+	var p *PanicError
+	if !errors.As(err, &p) || p.Item != 0 || len(p.Stack) == 0 {
+		t.Fatalf("expected a PanicError for item 0, got %v", err)
+	}
+	s.Resolve(5)
+	if v, err := s.Await(); err != nil || v != 2 {
+		t.Fatalf("expected 2, got %v, %v", v, err)
+	}
+}
+
+func TestStream_SupervisedMap(t *testing.T) {
+	// counts the items since the func was made, panicking on negative ones
+	counter := func() MappableFunc {
+		n := 0
+		return func(v interface{}) interface{} {
+			if v.(int) < 0 {
+				panic("negative")
+			}
+			n++
+			return n
+		}
+	}
+	for policy, expected := range map[RestartPolicy][]interface{}{
+		Resume:  {1, 2, 3},
+		Restart: {1, 2, 1},
+		Stop:    {1, 2},
+	} {
+		out, errs := Just(1, 2, -1, 3).SupervisedMap(counter, policy)
+		var panics []interface{}
+		drained := make(chan struct{})
+		go func() {
+			for err := range errs {
+				panics = append(panics, err.(*PanicError).Item)
+			}
+			close(drained)
+		}()
+		got := make([]interface{}, 0)
+		for v := range out {
+			got = append(got, v)
+		}
+		<-drained
+		if len(panics) != 1 || panics[0] != -1 {
+			t.Fatalf("%s: expected a panic on -1, got %v", policy, panics)
+		}
+		if len(got) != len(expected) {
+			t.Fatalf("%s: expected %v, got %v", policy, expected, got)
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Fatalf("%s: expected %v, got %v", policy, expected, got)
+			}
+		}
+	}
+}
+
+func TestSupervisor(t *testing.T) {
+	panics := make(chan string, 10)
+	sv := NewSupervisor(context.Background(), Restart, time.Millisecond).
+		SetMaxRestarts(1).
+		OnPanic(func(name string, err *PanicError) {
+			panics <- name
+		})
+	out := New(10)
+	s := With("supervised", sv.Context(), 10, func(v interface{}) (interface{}, error) {
+		if v == "boom" {
+			panic(v)
+		}
+		out <- v
+		return v, nil
+	})
+	s.Input() <- "boom"
+	s.Input() <- "a"
+	if v := <-out; v != "a" {
+		t.Fatalf("expected the restarted loop to handle a, got %v", v)
+	}
+	if sv.Restarts("supervised") != 1 {
+		t.Fatalf("expected 1 restart, got %d", sv.Restarts("supervised"))
+	}
+	s.Input() <- "boom"
+	select {
+	case <-sv.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the supervisor to stop after too many restarts")
+	}
+	if len(panics) != 2 {
+		t.Fatalf("expected 2 panics, got %d", len(panics))
+	}
+}
+
+func TestSupervisor_Once(t *testing.T) {
+	panics := make(chan string, 10)
+	sv := NewSupervisor(context.Background(), Stop, 0).
+		OnPanic(func(name string, err *PanicError) {
+			panics <- name
+		})
+	ran := make(chan interface{}, 10)
+	s := Once("once", sv.Context(), func(v interface{}) (interface{}, error) {
+		if v == "boom" {
+			panic(v)
+		}
+		ran <- v
+		return v, nil
+	})
+	s.Resolve("boom")
+	if len(panics) != 1 || <-panics != "once" {
+		t.Fatal("expected the panic to reach the supervisor")
+	}
+	select {
+	case <-sv.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the supervisor to stop")
+	}
+	s.Resolve("a")
+	if len(ran) != 0 {
+		t.Fatalf("expected no item to run, got %d", len(ran))
+	}
+}
+
+func TestSupervisor_Concurrency(t *testing.T) {
+	panics := make(chan string, 10)
+	sv := NewSupervisor(context.Background(), Stop, 0).
+		OnPanic(func(name string, err *PanicError) {
+			panics <- name
+		})
+	ran := make(chan interface{}, 10)
+	s := With("concurrent", sv.Context(), 10, func(v interface{}) (interface{}, error) {
+		if v == "boom" {
+			panic(v)
+		}
+		ran <- v
+		return v, nil
+	}).Concurrency(4, false)
+
+	// the loop is idle after the panic and must still hand it on
+	s.Input() <- "boom"
+	select {
+	case name := <-panics:
+		if name != "concurrent" {
+			t.Fatalf("unexpected Streams %q", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the panic never reached the supervisor")
+	}
+	select {
+	case <-sv.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the supervisor to stop")
+	}
+	if _, err := s.Submit("a").Await(); err == nil {
+		t.Fatal("expected no item to run after the supervisor stopped")
+	}
+	if len(ran) != 0 {
+		t.Fatalf("expected no item to run, got %d", len(ran))
+	}
+}